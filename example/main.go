@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -9,6 +10,8 @@ import (
 	_ "mongodb-data-migrate/example/scripts"
 	"mongodb-data-migrate/migrate"
 	"os"
+	"strconv"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -17,12 +20,26 @@ import (
 )
 
 var commandMigrate *cobra.Command
+var commandForce *cobra.Command
+var commandStatus *cobra.Command
+var commandFleet *cobra.Command
+var commandCleanupBackups *cobra.Command
 var (
 	argDsn       string
 	description  string
 	up           bool
 	down         bool
 	newMigration bool
+	toVersion    uint64
+	dryRun       bool
+	repair       bool
+	jsonOutput   bool
+
+	fleetConfig     string
+	fleetStatus     bool
+	parallelism     int
+	continueOnError bool
+	retention       time.Duration
 )
 
 func init() {
@@ -42,10 +59,86 @@ func init() {
 	commandMigrate.Flags().BoolVar(&up, "up", false, "migrate up")
 	commandMigrate.Flags().BoolVar(&down, "down", false, "migrate down")
 	commandMigrate.Flags().BoolVar(&newMigration, "new", false, "New migration")
+	commandMigrate.Flags().BoolVar(&dryRun, "dry-run", false, "print migrations plan without applying it")
+	commandMigrate.Flags().BoolVar(&repair, "repair", false, "accept checksums of changed applied migrations")
+	commandMigrate.Flags().Uint64Var(&toVersion, "to", 0, "migrate up or down to version (0 reverts all migrations)")
+
+	commandForce = &cobra.Command{
+		Use:   "force <version>",
+		Short: "Clear dirty state after manual repair and set database version.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(commandForce *cobra.Command, args []string) {
+			if err := forceVersion(commandForce, args); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		},
+	}
+	commandForce.Flags().StringVar(&argDsn, "dsn", "mongodb://localhost:27017", "db url")
+
+	commandStatus = &cobra.Command{
+		Use:   "status",
+		Short: "Show applied, pending and orphaned migrations.",
+		Run: func(commandStatus *cobra.Command, args []string) {
+			if err := showStatus(commandStatus, args); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		},
+	}
+	commandStatus.Flags().StringVar(&argDsn, "dsn", "mongodb://localhost:27017", "db url")
+	commandStatus.Flags().BoolVar(&jsonOutput, "json", false, "print status as json")
+
+	commandFleet = &cobra.Command{
+		Use:   "fleet",
+		Short: "Migrate or show versions of several clusters listed in config file.",
+		Run: func(commandFleet *cobra.Command, args []string) {
+			if err := migrateFleet(commandFleet, args); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		},
+	}
+	commandFleet.Flags().StringVar(&fleetConfig, "config", "fleet.json", "json file with list of targets")
+	commandFleet.Flags().BoolVar(&up, "up", false, "migrate up")
+	commandFleet.Flags().BoolVar(&down, "down", false, "migrate down")
+	commandFleet.Flags().BoolVar(&fleetStatus, "status", false, "show versions without migrating")
+	commandFleet.Flags().IntVar(&parallelism, "parallel", 1, "number of clusters processed at once")
+	commandFleet.Flags().BoolVar(&continueOnError, "continue-on-error", false, "process remaining clusters after failure")
+	commandFleet.Flags().BoolVar(&jsonOutput, "json", false, "print report as json")
+
+	commandCleanupBackups = &cobra.Command{
+		Use:   "cleanup-backups",
+		Short: "Drop collection snapshots older than retention period.",
+		Run: func(commandCleanupBackups *cobra.Command, args []string) {
+			if err := cleanupBackups(commandCleanupBackups, args); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		},
+	}
+	commandCleanupBackups.Flags().StringVar(&argDsn, "dsn", "mongodb://localhost:27017", "db url")
+	commandCleanupBackups.Flags().DurationVar(&retention, "retention", 7*24*time.Hour, "keep snapshots newer than this period")
+}
 
+// registerCommandMigrations registers migrations written as database commands in ./commands directory.
+func registerCommandMigrations() {
+	migrations, err := migrate.ReadDir("./commands")
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	migrate.MustRegisterMigrations(migrations...)
+	if err := migrate.Validate(); err != nil {
+		log.Fatal(err.Error())
+	}
 }
 
-func migrateDB(cmd *cobra.Command, args []string) error {
+func setupMigrate() {
+	registerCommandMigrations()
+
 	ctx := context.TODO()
 	clientOptions := options.Client().ApplyURI(argDsn)
 	client, err := mongo.Connect(ctx, clientOptions)
@@ -60,11 +153,167 @@ func migrateDB(cmd *cobra.Command, args []string) error {
 	migrate.SetDatabase(internal.DB, client)
 	migrate.SetMigrationsCollection("migrations")
 	migrate.SetLogger(log.New(os.Stdout, "INFO: ", 0))
+	migrate.SetRestoreOnFailure(true)
+	if isTerminal(os.Stderr) {
+		migrate.AddListener(&progressLine{out: os.Stderr})
+	}
+}
+
+func forceVersion(cmd *cobra.Command, args []string) error {
+	version, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	setupMigrate()
+	return migrate.Force(version)
+}
+
+func showStatus(cmd *cobra.Command, args []string) error {
+	setupMigrate()
+	statuses, err := migrate.Status()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(statuses)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tSTATE\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if status.Applied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Description, status.State(), appliedAt)
+	}
+	return w.Flush()
+}
+
+func cleanupBackups(cmd *cobra.Command, args []string) error {
+	setupMigrate()
+	dropped, err := migrate.CleanupBackups(retention)
+	for _, name := range dropped {
+		fmt.Println("dropped", name)
+	}
+	return err
+}
+
+func loadTargets(path string) ([]migrate.Target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var targets []migrate.Target
+	if err := json.NewDecoder(f).Decode(&targets); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return targets, nil
+}
+
+func migrateFleet(cmd *cobra.Command, args []string) error {
+	targets, err := loadTargets(fleetConfig)
+	if err != nil {
+		return err
+	}
+	registerCommandMigrations()
+	fleet := migrate.NewFleet(targets, migrate.GetMigrations()...)
+	fleet.SetParallelism(parallelism)
+	fleet.SetContinueOnError(continueOnError)
+	fleet.Configure(func(target migrate.Target, m *migrate.Migrate) {
+		m.SetLogger(log.New(os.Stdout, "INFO: ["+target.Name+"] ", 0))
+	})
+
+	var report *migrate.FleetReport
+	switch {
+	case up:
+		report, err = fleet.Up(migrate.AllAvailable)
+	case down:
+		report, err = fleet.Down(migrate.AllAvailable)
+	case fleetStatus:
+		report, err = fleet.Status()
+	default:
+		return fmt.Errorf("one of --up, --down or --status required")
+	}
+	if report != nil {
+		printFleetReport(os.Stdout, report)
+	}
+	return err
+}
+
+func printFleetReport(out io.Writer, report *migrate.FleetReport) {
+	if jsonOutput {
+		type result struct {
+			Name        string                    `json:"name"`
+			Database    string                    `json:"database"`
+			FromVersion uint64                    `json:"from_version"`
+			ToVersion   uint64                    `json:"to_version"`
+			Skipped     bool                      `json:"skipped,omitempty"`
+			Error       string                    `json:"error,omitempty"`
+			Status      []migrate.MigrationStatus `json:"status,omitempty"`
+		}
+		results := make([]result, 0, len(report.Results))
+		for _, r := range report.Results {
+			res := result{Name: r.Target.Name, Database: r.Target.Database, FromVersion: r.FromVersion,
+				ToVersion: r.ToVersion, Skipped: r.Skipped, Status: r.Status}
+			if r.Err != nil {
+				res.Error = r.Err.Error()
+			}
+			results = append(results, res)
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(results)
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDATABASE\tFROM\tTO\tDURATION\tRESULT")
+	for _, r := range report.Results {
+		result := "ok"
+		switch {
+		case r.Skipped:
+			result = "skipped"
+		case r.Err != nil:
+			result = r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", r.Target.Name, r.Target.Database, r.FromVersion, r.ToVersion,
+			r.Duration.Round(time.Millisecond), result)
+	}
+	_ = w.Flush()
+	if !report.Consistent() {
+		fmt.Fprintln(out, "warning: clusters are not on the same version")
+	}
+}
+
+func migrateDB(cmd *cobra.Command, args []string) error {
+	setupMigrate()
 
 	for index, v := range migrate.GetMigrations() {
 		log.Printf("migration :%d\t description :%s\tmigrations version :%d\n", index, v.Description, v.Version)
 	}
 
+	if dryRun && (up || down || cmd.Flags().Changed("to")) {
+		var plan *migrate.Plan
+		var err error
+		switch {
+		case cmd.Flags().Changed("to"):
+			plan, err = migrate.PlanTo(toVersion)
+		case up:
+			plan, err = migrate.PlanUp(migrate.AllAvailable)
+		default:
+			plan, err = migrate.PlanDown(migrate.AllAvailable)
+		}
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		printPlan(os.Stdout, plan)
+		return nil
+	}
+
 	if newMigration {
 
 		fName := fmt.Sprintf("./scripts/%s_%s.go", time.Now().Format("20060102150405"), description)
@@ -85,6 +334,18 @@ func migrateDB(cmd *cobra.Command, args []string) error {
 			log.Fatal(err.Error())
 		}
 		log.Printf("New migration created: %s\n", fName)
+	} else if repair {
+		fmt.Println("repair")
+		err := migrate.Repair()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	} else if cmd.Flags().Changed("to") {
+		fmt.Println("to", toVersion)
+		err := migrate.To(toVersion)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
 	} else if up {
 		fmt.Println("up")
 		err := migrate.Up(migrate.AllAvailable)
@@ -102,6 +363,20 @@ func migrateDB(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func printPlan(out io.Writer, plan *migrate.Plan) {
+	fmt.Fprintf(out, "current version: %d\n", plan.FromVersion)
+	if len(plan.Steps) == 0 {
+		fmt.Fprintln(out, "nothing to migrate")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DIRECTION\tVERSION\tDESCRIPTION\tHAS DOWN")
+	for _, step := range plan.Steps {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%v\n", step.Direction, step.Version, step.Description, step.HasDown)
+	}
+	_ = w.Flush()
+}
+
 func main() {
 
 	rootCmd := &cobra.Command{
@@ -113,6 +388,10 @@ func main() {
 	}
 
 	rootCmd.AddCommand(commandMigrate)
+	rootCmd.AddCommand(commandForce)
+	rootCmd.AddCommand(commandStatus)
+	rootCmd.AddCommand(commandFleet)
+	rootCmd.AddCommand(commandCleanupBackups)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"mongodb-data-migrate/migrate"
+)
+
+// progressLine renders progress of running migration in single terminal line.
+type progressLine struct {
+	migrate.NopListener
+	out     io.Writer
+	printed bool
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (l *progressLine) OnProgress(ctx context.Context, p migrate.Progress) {
+	line := fmt.Sprintf("%d %s: %d", p.Version, p.Description, p.Processed)
+	if p.Total > 0 {
+		line += fmt.Sprintf("/%d (%.1f%%)", p.Total, p.Percent())
+	}
+	line += fmt.Sprintf(" %.0f/s", p.Rate)
+	if p.ETA > 0 {
+		line += fmt.Sprintf(" eta %s", p.ETA.Round(time.Second))
+	}
+	// clear rest of previous line
+	fmt.Fprintf(l.out, "\r%s\033[K", line)
+	l.printed = true
+}
+
+func (l *progressLine) AfterMigration(ctx context.Context, e migrate.Event) {
+	l.finish()
+}
+
+func (l *progressLine) OnError(ctx context.Context, e migrate.Event) {
+	l.finish()
+}
+
+func (l *progressLine) finish() {
+	if l.printed {
+		fmt.Fprintln(l.out)
+		l.printed = false
+	}
+}
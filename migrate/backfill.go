@@ -0,0 +1,124 @@
+package migrate
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultBackfillBatchSize = 1000
+	backfillCheckpointKey    = "backfill"
+)
+
+// BackfillFunc transforms single document of backfill into write operation, e.g. *mongo.UpdateOneModel.
+// Nil model means document does not need changes.
+type BackfillFunc func(ctx context.Context, doc bson.Raw) (mongo.WriteModel, error)
+
+// Backfill is a batched data transformation of collection documents matching filter.
+// Documents are processed in batches ordered by "_id" and changes of each batch are written with one BulkWrite.
+// Last processed "_id" is saved in migration record after each batch,
+// so failed backfill continues from it on next "up" migration instead of processing everything again.
+// Progress is reported after each batch, total is estimated number of collection documents.
+type Backfill struct {
+	Collection string
+	// Filter selects documents to transform, nil means all documents.
+	Filter    interface{}
+	Transform BackfillFunc
+	// BatchSize is a number of documents processed at once. By default it is 1000.
+	BatchSize int
+}
+
+// backfillCheckpoint is a progress of backfill saved in migration record.
+type backfillCheckpoint struct {
+	LastID    bson.RawValue `bson:"last_id"`
+	Processed int64         `bson:"processed"`
+}
+
+// NewBackfillMigration returns migration which performs backfill on "up" and does nothing on "down",
+// so reverting it only removes its record and checkpoint, transformed documents stay as they are.
+// Migration is retryable, resumable and not transactional, so its dirty state does not block next runs.
+func NewBackfillMigration(version uint64, description string, backfill Backfill) Migration {
+	return Migration{
+		Version:     version,
+		Description: description,
+		UpContext:   backfill.run,
+		DownContext: func(ctx context.Context, client *mongo.Client) error { return nil },
+		Options: MigrationOptions{
+			Retryable:     true,
+			Resumable:     true,
+			NoTransaction: true,
+			Collections:   []string{backfill.Collection},
+		},
+	}
+}
+
+func (b Backfill) run(ctx context.Context, client *mongo.Client) error {
+	collection := client.Database(DatabaseFromContext(ctx)).Collection(b.Collection)
+	batchSize := b.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBackfillBatchSize
+	}
+	var filter interface{} = bson.M{}
+	if b.Filter != nil {
+		filter = b.Filter
+	}
+
+	var checkpoint backfillCheckpoint
+	resumed, err := loadRunData(ctx, backfillCheckpointKey, &checkpoint)
+	if err != nil {
+		return err
+	}
+	if resumed {
+		if info := runInfoFromContext(ctx); info != nil {
+			info.m.log().Info("backfill resumed", "version", info.migration.Version, "processed", checkpoint.Processed)
+		}
+	}
+
+	ReportProgress(ctx, checkpoint.Processed, 0)
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(batchSize))
+	for {
+		batchFilter := filter
+		if resumed {
+			batchFilter = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$gt": checkpoint.LastID}}}}
+		}
+		cursor, err := collection.Find(ctx, batchFilter, findOptions)
+		if err != nil {
+			return err
+		}
+		var docs []bson.Raw
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+
+		var models []mongo.WriteModel
+		for _, doc := range docs {
+			model, err := b.Transform(ctx, doc)
+			if err != nil {
+				return err
+			}
+			if model != nil {
+				models = append(models, model)
+			}
+		}
+		if len(models) > 0 {
+			if _, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+				return err
+			}
+		}
+
+		checkpoint.LastID = docs[len(docs)-1].Lookup("_id")
+		checkpoint.Processed += int64(len(docs))
+		resumed = true
+		if err := saveRunData(ctx, backfillCheckpointKey, checkpoint); err != nil {
+			return err
+		}
+		ReportProgress(ctx, checkpoint.Processed, 0)
+	}
+}
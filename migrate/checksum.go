@@ -0,0 +1,97 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ChecksumMismatch describes applied migration which was changed after it was applied.
+type ChecksumMismatch struct {
+	Version    uint64
+	Applied    string
+	Registered string
+}
+
+// ChecksumMismatchError returned by "up" migration if any applied migration was changed.
+// Use Repair to accept new checksums intentionally.
+type ChecksumMismatchError struct {
+	Mismatches []ChecksumMismatch
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	versions := make([]uint64, 0, len(e.Mismatches))
+	for _, mismatch := range e.Mismatches {
+		versions = append(versions, mismatch.Version)
+	}
+	return fmt.Sprintf("checksum mismatch for applied migrations %v", versions)
+}
+
+// fileChecksum returns checksum of migration source file.
+// Empty checksum returned if file is not available, e.g. binary runs without sources.
+func fileChecksum(file string) string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumMismatches compares checksums of registered migrations with applied ones.
+// Migrations without checksum on any side are not verified.
+func checksumMismatches(migrations []Migration, recs []versionRecord) []ChecksumMismatch {
+	var mismatches []ChecksumMismatch
+	for _, rec := range recs {
+		i := migrationIndex(migrations, rec.Version)
+		if i == -1 || rec.Checksum == "" || migrations[i].Checksum == "" {
+			continue
+		}
+		if rec.Checksum != migrations[i].Checksum {
+			mismatches = append(mismatches, ChecksumMismatch{
+				Version:    rec.Version,
+				Applied:    rec.Checksum,
+				Registered: migrations[i].Checksum,
+			})
+		}
+	}
+	return mismatches
+}
+
+func (m *Migrate) verifyChecksums(recs []versionRecord) error {
+	if mismatches := checksumMismatches(m.migrations, recs); len(mismatches) > 0 {
+		return &ChecksumMismatchError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// Repair replaces checksums of applied migrations with checksums of registered ones.
+// It should be used when applied migrations were changed intentionally.
+func (m *Migrate) Repair() error {
+	return m.RepairContext(context.Background())
+}
+
+// RepairContext acts like Repair but uses provided context for database operations.
+func (m *Migrate) RepairContext(ctx context.Context) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		recs, err := m.history(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mismatch := range checksumMismatches(m.migrations, recs) {
+			_, err := m.historyCollection().UpdateOne(ctx,
+				m.recordsFilter(bson.M{"version": mismatch.Version}),
+				bson.M{"$set": bson.M{"checksum": mismatch.Registered}},
+			)
+			if err != nil {
+				return err
+			}
+			m.log().Info("repaired checksum", "version", mismatch.Version, "applied", mismatch.Applied, "registered", mismatch.Registered)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,56 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "1_hello.go")
+	if err := ioutil.WriteFile(file, []byte("package migrations"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checksum := fileChecksum(file)
+	if len(checksum) != 64 {
+		t.Errorf("Unexpected checksum: %v", checksum)
+	}
+	if err := ioutil.WriteFile(file, []byte("package migrations // changed"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fileChecksum(file) == checksum {
+		t.Errorf("Checksum unexpectedly not changed")
+	}
+	if fileChecksum(filepath.Join(dir, "2_missing.go")) != "" {
+		t.Errorf("Unexpected checksum for missing file")
+	}
+}
+
+func TestChecksumMismatches(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Checksum: "a"},
+		{Version: 2, Checksum: "b"},
+		{Version: 3},
+	}
+	recs := []versionRecord{
+		{Version: 1, Checksum: "a"},
+		{Version: 2, Checksum: "c"},
+		{Version: 3, Checksum: "d"},
+		{Version: 4, Checksum: "e"},
+	}
+	mismatches := checksumMismatches(migrations, recs)
+	if len(mismatches) != 1 {
+		t.Errorf("Unexpected mismatches: %v", mismatches)
+		return
+	}
+	if mismatches[0] != (ChecksumMismatch{Version: 2, Applied: "c", Registered: "b"}) {
+		t.Errorf("Unexpected mismatch: %v", mismatches[0])
+	}
+}
@@ -0,0 +1,165 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	upCommandsExt   = ".up.json"
+	downCommandsExt = ".down.json"
+)
+
+// commandFiles are files of single command migration.
+type commandFiles struct {
+	description string
+	up          string
+	down        string
+}
+
+// ReadDir loads migrations from "<version>_<description>.up.json" and "<version>_<description>.down.json"
+// files of directory. Each file holds an array of database commands in MongoDB Extended JSON, e.g.
+//
+//	[
+//		{"create": "users"},
+//		{"createIndexes": "users", "indexes": [{"key": {"email": 1}, "name": "email_1", "unique": true}]}
+//	]
+//
+// Commands are executed in order with Database.RunCommand on database migration is performed on.
+// "Down" file is optional, other files of directory are ignored.
+// Loaded migrations may be merged with Go ones by Migrate.AddMigrations or RegisterMigrations.
+func ReadDir(dir string) ([]Migration, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	return readCommandFiles(names, func(name string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join(dir, name))
+	})
+}
+
+// readCommandFiles builds migrations from command files, readFile returns content of file by name.
+func readCommandFiles(names []string, readFile func(name string) ([]byte, error)) ([]Migration, error) {
+	byVersion := make(map[uint64]*commandFiles)
+	for _, name := range names {
+		var ext string
+		switch {
+		case strings.HasSuffix(name, upCommandsExt):
+			ext = upCommandsExt
+		case strings.HasSuffix(name, downCommandsExt):
+			ext = downCommandsExt
+		default:
+			continue
+		}
+		version, description, err := extractVersionDescriptionExt(name, ext)
+		if err != nil {
+			return nil, err
+		}
+		files, ok := byVersion[version]
+		if !ok {
+			files = &commandFiles{description: description}
+			byVersion[version] = files
+		}
+		if files.description != description {
+			return nil, fmt.Errorf("migrations %q and %q have the same version", files.description, description)
+		}
+		if ext == upCommandsExt {
+			files.up = name
+		} else {
+			files.down = name
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version, files := range byVersion {
+		if files.up == "" {
+			return nil, fmt.Errorf("%q has no %q file", files.down, upCommandsExt)
+		}
+		migration, err := newCommandMigration(version, files, readFile)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration)
+	}
+	migrationSort(migrations)
+	return migrations, nil
+}
+
+func newCommandMigration(version uint64, files *commandFiles, readFile func(name string) ([]byte, error)) (Migration, error) {
+	migration := Migration{
+		Version:     version,
+		Description: files.description,
+		// commands like index builds can not run inside transaction on all servers
+		Options: MigrationOptions{NoTransaction: true},
+	}
+	checksum := sha256.New()
+
+	data, err := readFile(files.up)
+	if err != nil {
+		return Migration{}, err
+	}
+	checksum.Write(data)
+	commands, err := parseCommands(files.up, data)
+	if err != nil {
+		return Migration{}, err
+	}
+	migration.UpContext = runCommands(files.up, commands)
+
+	if files.down != "" {
+		data, err := readFile(files.down)
+		if err != nil {
+			return Migration{}, err
+		}
+		checksum.Write(data)
+		commands, err := parseCommands(files.down, data)
+		if err != nil {
+			return Migration{}, err
+		}
+		migration.DownContext = runCommands(files.down, commands)
+	}
+
+	migration.Checksum = hex.EncodeToString(checksum.Sum(nil))
+	return migration, nil
+}
+
+// parseCommands parses array of commands in Extended JSON.
+func parseCommands(name string, data []byte) ([]bson.D, error) {
+	// array can not be unmarshaled directly, so it`s wrapped into document
+	wrapped := make([]byte, 0, len(data)+len(`{"commands":}`))
+	wrapped = append(wrapped, `{"commands":`...)
+	wrapped = append(wrapped, data...)
+	wrapped = append(wrapped, '}')
+	var doc struct {
+		Commands []bson.D `bson:"commands"`
+	}
+	if err := bson.UnmarshalExtJSON(wrapped, false, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return doc.Commands, nil
+}
+
+func runCommands(name string, commands []bson.D) MigrationContextFunc {
+	return func(ctx context.Context, client *mongo.Client) error {
+		db := client.Database(DatabaseFromContext(ctx))
+		for i, cmd := range commands {
+			if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+				return fmt.Errorf("%s: command %d: %w", name, i, err)
+			}
+		}
+		return nil
+	}
+}
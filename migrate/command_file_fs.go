@@ -0,0 +1,34 @@
+//go:build go1.16
+// +build go1.16
+
+package migrate
+
+import (
+	"io/fs"
+	"path"
+)
+
+// ReadFS acts like ReadDir but loads migrations from directory of file system,
+// e.g. from embed.FS to ship migrations inside binary:
+//
+//	//go:embed migrations/*.json
+//	var migrationFiles embed.FS
+//
+//	migrations, err := migrate.ReadFS(migrationFiles, "migrations")
+//
+// Use "." as dir to load migrations from root of file system.
+func ReadFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return readCommandFiles(names, func(name string) ([]byte, error) {
+		return fs.ReadFile(fsys, path.Join(dir, name))
+	})
+}
@@ -0,0 +1,46 @@
+//go:build go1.16
+// +build go1.16
+
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_create.up.json":      {Data: []byte(`[{"create": "users"}]`)},
+		"migrations/1_create.down.json":    {Data: []byte(`[{"drop": "users"}]`)},
+		"migrations/2_insert.up.json":      {Data: []byte(`[{"insert": "users", "documents": [{"a": 1}]}]`)},
+		"migrations/nested/3_skip.up.json": {Data: []byte(`[]`)},
+		"migrations/notes.txt":             {Data: []byte(`ignored`)},
+	}
+	migrations, err := ReadFS(fsys, "migrations")
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if len(migrations) != 2 {
+		t.Errorf("Unexpected migrations: %v", migrations)
+		return
+	}
+	if migrations[0].Version != 1 || migrations[0].Description != "create" || migrations[0].down() == nil {
+		t.Errorf("Unexpected migration: %+v", migrations[0])
+	}
+	if migrations[1].Version != 2 || migrations[1].Description != "insert" || migrations[1].down() != nil {
+		t.Errorf("Unexpected migration: %+v", migrations[1])
+	}
+}
+
+func TestReadFSInvalidName(t *testing.T) {
+	fsys := fstest.MapFS{
+		"v1_create.up.json": {Data: []byte(`[]`)},
+	}
+	if _, err := ReadFS(fsys, "."); err == nil {
+		t.Errorf("Expected error for invalid file name")
+	}
+	if _, err := ReadFS(fsys, "missing"); err == nil {
+		t.Errorf("Expected error for missing directory")
+	}
+}
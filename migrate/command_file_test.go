@@ -0,0 +1,98 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestReadDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"2_add_index.up.json":   `[{"createIndexes": "users", "indexes": [{"key": {"email": 1}, "name": "email_1"}]}]`,
+		"2_add_index.down.json": `[{"dropIndexes": "users", "index": "email_1"}]`,
+		"1_create.up.json":      `[{"create": "users"}, {"insert": "users", "documents": [{"n": {"$numberLong": "1"}}]}]`,
+		"README.md":             `ignored`,
+	})
+	defer os.RemoveAll(dir)
+
+	migrations, err := ReadDir(dir)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if len(migrations) != 2 {
+		t.Errorf("Unexpected migrations: %v", migrations)
+		return
+	}
+	if migrations[0].Version != 1 || migrations[0].Description != "create" || migrations[0].down() != nil {
+		t.Errorf("Unexpected migration: %+v", migrations[0])
+	}
+	if migrations[1].Version != 2 || migrations[1].Description != "add_index" || migrations[1].down() == nil {
+		t.Errorf("Unexpected migration: %+v", migrations[1])
+	}
+	if migrations[0].Checksum == "" || migrations[0].Checksum == migrations[1].Checksum {
+		t.Errorf("Unexpected checksums: %v %v", migrations[0].Checksum, migrations[1].Checksum)
+	}
+}
+
+func TestReadDirErrors(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"invalid json":    {"1_a.up.json": `[{"create": }]`},
+		"invalid version": {"a_b.up.json": `[]`},
+		"missing up":      {"1_a.down.json": `[]`},
+		"same version":    {"1_a.up.json": `[]`, "1_b.up.json": `[]`},
+	} {
+		dir := writeFiles(t, files)
+		if _, err := ReadDir(dir); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestParseCommands(t *testing.T) {
+	commands, err := parseCommands("test", []byte(`[{"insert": "users", "documents": [{"n": {"$numberLong": "5"}}]}]`))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	expected := []bson.D{{
+		{Key: "insert", Value: "users"},
+		{Key: "documents", Value: bson.A{bson.D{{Key: "n", Value: int64(5)}}}},
+	}}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Unexpected commands: %#v", commands)
+	}
+}
+
+func TestAddMigrations(t *testing.T) {
+	migrate := NewMigrate("db", nil, Migration{Version: 1})
+	if err := migrate.AddMigrations(Migration{Version: 2}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if err := migrate.AddMigrations(Migration{Version: 3}, Migration{Version: 1}); err == nil {
+		t.Errorf("Expected error for duplicate version")
+		return
+	}
+	if len(migrate.migrations) != 2 {
+		t.Errorf("Unexpected migrations: %v", migrate.migrations)
+	}
+}
@@ -0,0 +1,56 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+)
+
+type runInfoKey struct{}
+
+// runInfo describes migration which is being performed. It`s passed to callbacks inside context.
+type runInfo struct {
+	m         *Migrate
+	migration Migration
+	direction Direction
+	progress  *progressTracker
+}
+
+func withRunInfo(ctx context.Context, info *runInfo) context.Context {
+	return context.WithValue(ctx, runInfoKey{}, info)
+}
+
+func runInfoFromContext(ctx context.Context) *runInfo {
+	info, _ := ctx.Value(runInfoKey{}).(*runInfo)
+	return info
+}
+
+// DatabaseFromContext returns name of database migration is performed on.
+// It should be used by migrations applied to several databases, e.g. by TenantMigrate.
+// Empty string returned if ctx was not passed to migration callback by Migrate.
+func DatabaseFromContext(ctx context.Context) string {
+	if info := runInfoFromContext(ctx); info != nil {
+		return info.m.dbName
+	}
+	return ""
+}
+
+var errNotRunning = errors.New("context does not belong to running migration")
+
+// saveRunData stores value in record of running migration under key.
+// Data is kept while migration is applied, so "down" migration may use data saved by "up" one.
+func saveRunData(ctx context.Context, key string, value interface{}) error {
+	info := runInfoFromContext(ctx)
+	if info == nil {
+		return errNotRunning
+	}
+	return info.m.saveRecordData(ctx, info.migration.Version, key, value)
+}
+
+// loadRunData decodes value saved under key by running migration. It returns false if there is no such value.
+func loadRunData(ctx context.Context, key string, value interface{}) (bool, error) {
+	info := runInfoFromContext(ctx)
+	if info == nil {
+		return false, errNotRunning
+	}
+	return info.m.loadRecordData(ctx, info.migration.Version, key, value)
+}
@@ -0,0 +1,170 @@
+package migrate
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MissingDependencyError returned if migration depends on migration which is not registered.
+type MissingDependencyError struct {
+	Version   uint64
+	DependsOn uint64
+}
+
+func (e *MissingDependencyError) Error() string {
+	return fmt.Sprintf("migration %v depends on unknown migration %v", e.Version, e.DependsOn)
+}
+
+// DependencyCycleError returned if migrations depend on each other.
+// Versions consists migrations of cycle and migrations depending on them.
+type DependencyCycleError struct {
+	Versions []uint64
+}
+
+func (e *DependencyCycleError) Error() string {
+	return fmt.Sprintf("migrations %v have cyclic dependencies", e.Versions)
+}
+
+// UnmetDependencyError returned if migrations can not be performed without breaking dependencies:
+// "up" migration depends on migration which is not applied and will not be applied by the same plan,
+// or "down" migration is required by applied migration which will not be reverted before it.
+type UnmetDependencyError struct {
+	Version   uint64
+	DependsOn uint64
+	Direction Direction
+}
+
+func (e *UnmetDependencyError) Error() string {
+	if e.Direction == DirectionDown {
+		return fmt.Sprintf("migration %v can not be reverted, applied migration %v depends on it", e.DependsOn, e.Version)
+	}
+	return fmt.Sprintf("migration %v depends on migration %v which is not applied", e.Version, e.DependsOn)
+}
+
+// sortByDependencies returns migrations in topological order of dependencies.
+// Independent migrations are ordered by version, so migrations without dependencies keep linear order.
+// If ignoreMissing is set dependencies on migrations out of list are ignored.
+func sortByDependencies(migrations []Migration, ignoreMissing bool) ([]Migration, error) {
+	index := make(map[uint64]int, len(migrations))
+	for i, migration := range migrations {
+		index[migration.Version] = i
+	}
+	inDegree := make([]int, len(migrations))
+	dependents := make([][]int, len(migrations))
+	for i, migration := range migrations {
+		for _, dependency := range migration.Options.DependsOn {
+			j, ok := index[dependency]
+			if !ok {
+				if ignoreMissing {
+					continue
+				}
+				return nil, &MissingDependencyError{Version: migration.Version, DependsOn: dependency}
+			}
+			inDegree[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var ready []int
+	for i := range migrations {
+		if inDegree[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	sorted := make([]Migration, 0, len(migrations))
+	for len(ready) > 0 {
+		// take ready migration with lowest version
+		next := 0
+		for k := range ready {
+			if migrations[ready[k]].Version < migrations[ready[next]].Version {
+				next = k
+			}
+		}
+		i := ready[next]
+		ready = append(ready[:next], ready[next+1:]...)
+		sorted = append(sorted, migrations[i])
+		for _, j := range dependents[i] {
+			inDegree[j]--
+			if inDegree[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+
+	if len(sorted) < len(migrations) {
+		var versions []uint64
+		for i, migration := range migrations {
+			if inDegree[i] > 0 {
+				versions = append(versions, migration.Version)
+			}
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+		return nil, &DependencyCycleError{Versions: versions}
+	}
+	return sorted, nil
+}
+
+// dependencyOrder returns migrations in order they should be applied.
+// Dependencies are validated before planning, so invalid ones only fall back to version order.
+func dependencyOrder(migrations []Migration) []Migration {
+	sorted, err := sortByDependencies(migrations, true)
+	if err != nil {
+		sorted = make([]Migration, len(migrations))
+		copy(sorted, migrations)
+		migrationSort(sorted)
+	}
+	return sorted
+}
+
+// dependentVersions returns versions of migrations which directly depend on each version.
+func dependentVersions(migrations []Migration) map[uint64][]uint64 {
+	dependents := make(map[uint64][]uint64)
+	for _, migration := range migrations {
+		for _, dependency := range migration.Options.DependsOn {
+			dependents[dependency] = append(dependents[dependency], migration.Version)
+		}
+	}
+	return dependents
+}
+
+// checkUpDependencies returns *UnmetDependencyError if any of migrations depends on migration
+// which is neither applied nor preceding it in list.
+func checkUpDependencies(migrations []Migration, applied map[uint64]bool) error {
+	done := make(map[uint64]bool, len(applied)+len(migrations))
+	for version := range applied {
+		done[version] = true
+	}
+	for _, migration := range migrations {
+		for _, dependency := range migration.Options.DependsOn {
+			if !done[dependency] {
+				return &UnmetDependencyError{Version: migration.Version, DependsOn: dependency, Direction: DirectionUp}
+			}
+		}
+		done[migration.Version] = true
+	}
+	return nil
+}
+
+// checkDownDependencies returns *UnmetDependencyError if migration is reverted before its applied dependent.
+// Migrations must be listed in order of reverting.
+func checkDownDependencies(migrations, reverted []Migration, applied map[uint64]bool) error {
+	dependents := dependentVersions(migrations)
+	done := make(map[uint64]bool, len(reverted))
+	for _, migration := range reverted {
+		for _, dependent := range dependents[migration.Version] {
+			if applied[dependent] && !done[dependent] {
+				return &UnmetDependencyError{Version: dependent, DependsOn: migration.Version, Direction: DirectionDown}
+			}
+		}
+		done[migration.Version] = true
+	}
+	return nil
+}
+
+// Validate checks dependencies of migrations.
+// It returns *MissingDependencyError if migration depends on unknown one
+// and *DependencyCycleError if migrations depend on each other.
+func (m *Migrate) Validate() error {
+	_, err := sortByDependencies(m.migrations, false)
+	return err
+}
@@ -0,0 +1,77 @@
+package migrate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func versions(migrations []Migration) []uint64 {
+	var ret []uint64
+	for _, migration := range migrations {
+		ret = append(ret, migration.Version)
+	}
+	return ret
+}
+
+func TestSortByDependencies(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1},
+		{Version: 2, Options: MigrationOptions{DependsOn: []uint64{4}}},
+		{Version: 3},
+		{Version: 4, Options: MigrationOptions{DependsOn: []uint64{1}}},
+		{Version: 5, Options: MigrationOptions{DependsOn: []uint64{2, 3}}},
+	}
+	sorted, err := sortByDependencies(migrations, false)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(versions(sorted), []uint64{1, 3, 4, 2, 5}) {
+		t.Errorf("Unexpected order: %v", versions(sorted))
+	}
+}
+
+func TestSortByDependenciesErrors(t *testing.T) {
+	_, err := sortByDependencies([]Migration{
+		{Version: 1, Options: MigrationOptions{DependsOn: []uint64{7}}},
+	}, false)
+	var missingErr *MissingDependencyError
+	if !errors.As(err, &missingErr) || missingErr.Version != 1 || missingErr.DependsOn != 7 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	_, err = sortByDependencies([]Migration{
+		{Version: 1},
+		{Version: 2, Options: MigrationOptions{DependsOn: []uint64{3}}},
+		{Version: 3, Options: MigrationOptions{DependsOn: []uint64{2, 7}}},
+	}, true)
+	var cycleErr *DependencyCycleError
+	if !errors.As(err, &cycleErr) || !reflect.DeepEqual(cycleErr.Versions, []uint64{2, 3}) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestCheckDependencies(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1},
+		{Version: 2, Options: MigrationOptions{DependsOn: []uint64{1}}},
+	}
+	if err := checkUpDependencies(migrations, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	var unmetErr *UnmetDependencyError
+	err := checkUpDependencies(migrations[1:], nil)
+	if !errors.As(err, &unmetErr) || unmetErr.Version != 2 || unmetErr.DependsOn != 1 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	applied := map[uint64]bool{1: true, 2: true}
+	if err := checkDownDependencies(migrations, []Migration{migrations[1], migrations[0]}, applied); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	err = checkDownDependencies(migrations, migrations[:1], applied)
+	if !errors.As(err, &unmetErr) || unmetErr.Direction != DirectionDown || unmetErr.Version != 2 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
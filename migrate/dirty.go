@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// failureRecordTimeout limits recording of migration failure, context of migration may be already done.
+const failureRecordTimeout = 30 * time.Second
+
+// DirtyError returned if previous migration was started but not completed.
+// Database should be repaired manually and then Force used to clear dirty state.
+type DirtyError struct {
+	Version   uint64
+	Direction Direction
+	Err       string
+	StartedAt time.Time
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("migration %v is dirty after failed %s started at %s: %s",
+		e.Version, e.Direction, e.StartedAt.Format(time.RFC3339), e.Err)
+}
+
+// markStarted marks migration record as dirty before migration callback runs.
+func (m *Migrate) markStarted(ctx context.Context, migration Migration, direction Direction) error {
+	set := bson.M{
+		"dirty":      true,
+		"direction":  direction.String(),
+		"started_at": time.Now().UTC(),
+	}
+	update := bson.M{
+		"$set":   set,
+		"$unset": bson.M{"error": ""},
+	}
+	if direction == DirectionUp {
+		set["description"] = migration.Description
+		update["$setOnInsert"] = bson.M{"applied": false}
+	}
+	_, err := m.historyCollection().UpdateOne(ctx,
+		m.recordsFilter(bson.M{"version": migration.Version}),
+		update,
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// markFailed stores error of migration, record stays dirty.
+func (m *Migrate) markFailed(version uint64, migrationErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), failureRecordTimeout)
+	defer cancel()
+	_, err := m.historyCollection().UpdateOne(ctx,
+		m.recordsFilter(bson.M{"version": version, "dirty": true}),
+		bson.M{"$set": bson.M{"error": migrationErr.Error()}},
+	)
+	return err
+}
+
+// markRestored removes record of failed "up" migration after its collections are restored from snapshots.
+func (m *Migrate) markRestored(version uint64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), failureRecordTimeout)
+	defer cancel()
+	return m.markReverted(ctx, version)
+}
+
+// checkDirty returns *DirtyError if any migration is dirty.
+// Failed "up" of resumable migration is performed again by next run, so its dirty state is ignored.
+// Failed "down" leaves migration partially reverted, so it blocks runs of any migration.
+func (m *Migrate) checkDirty(ctx context.Context) error {
+	filter := bson.M{"dirty": true}
+	var resumable []uint64
+	for _, migration := range m.migrations {
+		if migration.Options.Resumable {
+			resumable = append(resumable, migration.Version)
+		}
+	}
+	if len(resumable) > 0 {
+		filter["$nor"] = bson.A{bson.M{"version": bson.M{"$in": resumable}, "direction": DirectionUp.String()}}
+	}
+
+	var rec versionRecord
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "version", Value: 1}})
+	err := m.historyCollection().FindOne(ctx, m.recordsFilter(filter), findOptions).Decode(&rec)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	direction := DirectionUp
+	if rec.Direction == DirectionDown.String() {
+		direction = DirectionDown
+	}
+	return &DirtyError{Version: rec.Version, Direction: direction, Err: rec.Error, StartedAt: rec.StartedAt}
+}
+
+// Force clears dirty state and forcibly sets database version like SetVersion does.
+// It should be used after manual repair of database left by failed migration.
+func (m *Migrate) Force(version uint64) error {
+	return m.ForceContext(context.Background(), version)
+}
+
+// ForceContext acts like Force but uses provided context for database operations.
+func (m *Migrate) ForceContext(ctx context.Context, version uint64) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		if _, err := m.history(ctx); err != nil {
+			return err
+		}
+		// dirty "up" migrations were never applied, dirty "down" ones are still applied
+		notApplied := m.recordsFilter(bson.M{"dirty": true, "applied": false})
+		cursor, err := m.historyCollection().Find(ctx, notApplied)
+		if err != nil {
+			return err
+		}
+		var recs []versionRecord
+		if err := cursor.All(ctx, &recs); err != nil {
+			return err
+		}
+		if _, err := m.historyCollection().DeleteMany(ctx, notApplied); err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			// database is repaired manually, so changes of failed run must not be undone later
+			if err := m.clearUndo(ctx, rec.Version); err != nil {
+				return err
+			}
+		}
+		_, err = m.historyCollection().UpdateMany(ctx,
+			m.recordsFilter(bson.M{"dirty": true}),
+			bson.M{"$unset": bson.M{"dirty": "", "direction": "", "started_at": "", "error": ""}},
+		)
+		if err != nil {
+			return err
+		}
+
+		var description string
+		if i := migrationIndex(m.migrations, version); i != -1 {
+			description = m.migrations[i].Description
+		}
+		if err := m.SetVersionContext(ctx, version, description); err != nil {
+			return err
+		}
+		m.log().Info("forced version", "version", version, "description", description)
+		return nil
+	})
+}
@@ -0,0 +1,195 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Target is a named database of one cluster managed by Fleet.
+type Target struct {
+	Name     string `json:"name"`
+	DSN      string `json:"dsn"`
+	Database string `json:"database"`
+}
+
+// Fleet applies the same migrations to databases of several clusters, e.g. regional ones,
+// which must stay on the same schema version. Each target is connected on demand and disconnected afterwards.
+type Fleet struct {
+	targets         []Target
+	migrations      []Migration
+	parallelism     int
+	continueOnError bool
+	configure       []func(target Target, m *Migrate)
+}
+
+// FleetResult is a result of operation on single target.
+// Skipped is set if operation was not started because of previous failure or cancelled context.
+// Status is filled by Fleet.Status only.
+type FleetResult struct {
+	Target      Target
+	FromVersion uint64
+	ToVersion   uint64
+	Duration    time.Duration
+	Skipped     bool
+	Err         error
+	Status      []MigrationStatus
+}
+
+// FleetReport is a summary of operation on all targets in order of targets.
+type FleetReport struct {
+	Results []FleetResult
+}
+
+// Failed returns results of failed targets.
+func (r *FleetReport) Failed() []FleetResult {
+	var failed []FleetResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Consistent reports whether all targets succeeded and have the same version.
+func (r *FleetReport) Consistent() bool {
+	for _, result := range r.Results {
+		if result.Err != nil || result.Skipped || result.ToVersion != r.Results[0].ToVersion {
+			return false
+		}
+	}
+	return true
+}
+
+// NewFleet returns Fleet for provided targets and migrations.
+// Use GetMigrations to apply globally registered migrations.
+func NewFleet(targets []Target, migrations ...Migration) *Fleet {
+	internalMigrations := make([]Migration, len(migrations))
+	copy(internalMigrations, migrations)
+	return &Fleet{
+		targets:     append([]Target(nil), targets...),
+		migrations:  internalMigrations,
+		parallelism: 1,
+	}
+}
+
+// SetParallelism sets how many targets are processed at once. By default targets are processed sequentially.
+func (f *Fleet) SetParallelism(n int) {
+	f.parallelism = n
+}
+
+// SetContinueOnError makes Fleet process remaining targets after failure.
+// By default no new targets are started after first failure.
+func (f *Fleet) SetContinueOnError(continueOnError bool) {
+	f.continueOnError = continueOnError
+}
+
+// Configure registers function which configures Migrate of each target,
+// e.g. to set logger, listeners or migrations collection.
+func (f *Fleet) Configure(fn func(target Target, m *Migrate)) {
+	f.configure = append(f.configure, fn)
+}
+
+// Up performs "up" migrations on every target. See Migrate.Up for n meaning.
+func (f *Fleet) Up(n int) (*FleetReport, error) {
+	return f.UpContext(context.Background(), n)
+}
+
+// UpContext acts like Up but passes ctx to every migration and database operation.
+func (f *Fleet) UpContext(ctx context.Context, n int) (*FleetReport, error) {
+	return f.run(ctx, func(ctx context.Context, m *Migrate, result *FleetResult) error {
+		return m.UpContext(ctx, n)
+	})
+}
+
+// Down performs "down" migrations on every target. See Migrate.Down for n meaning.
+func (f *Fleet) Down(n int) (*FleetReport, error) {
+	return f.DownContext(context.Background(), n)
+}
+
+// DownContext acts like Down but passes ctx to every migration and database operation.
+func (f *Fleet) DownContext(ctx context.Context, n int) (*FleetReport, error) {
+	return f.run(ctx, func(ctx context.Context, m *Migrate, result *FleetResult) error {
+		return m.DownContext(ctx, n)
+	})
+}
+
+// Status collects status of migrations on every target.
+func (f *Fleet) Status() (*FleetReport, error) {
+	return f.StatusContext(context.Background())
+}
+
+// StatusContext acts like Status but uses provided context for database operations.
+func (f *Fleet) StatusContext(ctx context.Context) (*FleetReport, error) {
+	return f.run(ctx, func(ctx context.Context, m *Migrate, result *FleetResult) error {
+		var err error
+		result.Status, err = m.StatusContext(ctx)
+		return err
+	})
+}
+
+// run connects to every target and calls fn for its Migrate.
+// Returned error is not nil if any target failed, report holds result of each target.
+func (f *Fleet) run(ctx context.Context, fn func(ctx context.Context, m *Migrate, result *FleetResult) error) (*FleetReport, error) {
+	report := &FleetReport{Results: make([]FleetResult, len(f.targets))}
+	errs, started := forEach(ctx, len(f.targets), f.parallelism, !f.continueOnError, func(ctx context.Context, i int) error {
+		result := &report.Results[i]
+		started := time.Now()
+		defer func() { result.Duration = time.Since(started) }()
+		result.Err = f.runTarget(ctx, f.targets[i], result, fn)
+		return result.Err
+	})
+
+	failed := 0
+	for i, target := range f.targets {
+		report.Results[i].Target = target
+		report.Results[i].Skipped = !started[i]
+		if errs[i] != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return report, fmt.Errorf("migrations failed for %d of %d targets", failed, len(f.targets))
+	}
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func (f *Fleet) runTarget(ctx context.Context, target Target, result *FleetResult,
+	fn func(ctx context.Context, m *Migrate, result *FleetResult) error) error {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(target.DSN))
+	if err != nil {
+		return fmt.Errorf("%s: %w", target.Name, err)
+	}
+	defer func() {
+		// parent context may be already cancelled, connection should be closed anyway
+		disconnectCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = client.Disconnect(disconnectCtx)
+	}()
+	if err := client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("%s: %w", target.Name, err)
+	}
+
+	m := NewMigrate(target.Database, client, f.migrations...)
+	m.SetMetricsTarget(target.Name)
+	for _, configure := range f.configure {
+		configure(target, m)
+	}
+	if result.FromVersion, _, err = m.VersionContext(ctx); err != nil {
+		return fmt.Errorf("%s: %w", target.Name, err)
+	}
+	if err := fn(ctx, m, result); err != nil {
+		return fmt.Errorf("%s: %w", target.Name, err)
+	}
+	if result.ToVersion, _, err = m.VersionContext(ctx); err != nil {
+		return fmt.Errorf("%s: %w", target.Name, err)
+	}
+	return nil
+}
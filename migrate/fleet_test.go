@@ -0,0 +1,46 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFleetReportConsistent(t *testing.T) {
+	report := &FleetReport{Results: []FleetResult{
+		{Target: Target{Name: "eu"}, ToVersion: 2},
+		{Target: Target{Name: "us"}, ToVersion: 2},
+	}}
+	if !report.Consistent() {
+		t.Errorf("Report must be consistent: %+v", report)
+		return
+	}
+
+	report.Results[1].ToVersion = 1
+	if report.Consistent() {
+		t.Errorf("Report with different versions must not be consistent: %+v", report)
+		return
+	}
+
+	report.Results[1] = FleetResult{Target: Target{Name: "us"}, ToVersion: 2, Err: errors.New("failure")}
+	if report.Consistent() || len(report.Failed()) != 1 {
+		t.Errorf("Report with failed target must not be consistent: %+v", report)
+		return
+	}
+}
+
+func TestFleetStopOnError(t *testing.T) {
+	fleet := NewFleet([]Target{
+		{Name: "broken", DSN: "invalid://dsn", Database: "db"},
+		{Name: "next", DSN: "invalid://dsn", Database: "db"},
+	})
+	report, err := fleet.UpContext(context.Background(), AllAvailable)
+	if err == nil {
+		t.Errorf("Expected error for invalid dsn")
+		return
+	}
+	if report.Results[0].Err == nil || report.Results[0].Skipped || !report.Results[1].Skipped {
+		t.Errorf("Unexpected results: %+v", report.Results)
+		return
+	}
+}
@@ -1,12 +1,16 @@
 package migrate
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -14,9 +18,14 @@ import (
 var globalMigrate = NewMigrate("", nil)
 
 func extractVersionDescription(name string) (uint64, string, error) {
+	return extractVersionDescriptionExt(name, ".go")
+}
+
+// extractVersionDescriptionExt parses "<version>_<description><ext>" file name.
+func extractVersionDescriptionExt(name, ext string) (uint64, string, error) {
 	base := filepath.Base(name)
 
-	if ext := filepath.Ext(base); ext != ".go" {
+	if !strings.HasSuffix(base, ext) {
 		return 0, "", fmt.Errorf("can not extract version from %q", base)
 	}
 
@@ -30,12 +39,12 @@ func extractVersionDescription(name string) (uint64, string, error) {
 		return 0, "", err
 	}
 
-	description := base[idx+1 : len(base)-len(".go")]
+	description := base[idx+1 : len(base)-len(ext)]
 
 	return version, description, nil
 }
 
-func internalRegister(up, down MigrationFunc, skip int) error {
+func internalRegister(migration Migration, skip int) error {
 	_, file, _, _ := runtime.Caller(skip)
 	version, description, err := extractVersionDescription(file)
 	if err != nil {
@@ -44,12 +53,17 @@ func internalRegister(up, down MigrationFunc, skip int) error {
 	if hasVersion(globalMigrate.migrations, version) {
 		return fmt.Errorf("migration with version %v already registered", version)
 	}
-	globalMigrate.migrations = append(globalMigrate.migrations, Migration{
-		Version:     version,
-		Description: description,
-		Up:          up,
-		Down:        down,
-	})
+	migration.Version = version
+	migration.Description = description
+	if migration.Checksum == "" {
+		migration.Checksum = fileChecksum(file)
+	}
+	// dependencies may be registered later, so only cycles are detected here, see Validate
+	migrations := append(RegisteredMigrations(), migration)
+	if _, err := sortByDependencies(migrations, true); err != nil {
+		return err
+	}
+	globalMigrate.migrations = migrations
 	return nil
 }
 
@@ -74,12 +88,88 @@ func internalRegister(up, down MigrationFunc, skip int) error {
 //		})
 //	 }
 func Register(up, down MigrationFunc) error {
-	return internalRegister(up, down, 2)
+	return internalRegister(Migration{Up: up, Down: down}, 2)
 }
 
 // MustRegister acts like Register but panics on errors.
 func MustRegister(up, down MigrationFunc) {
-	if err := internalRegister(up, down, 2); err != nil {
+	if err := internalRegister(Migration{Up: up, Down: down}, 2); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterContext acts like Register but accepts context-aware callbacks.
+// Context passed to callbacks is the one provided to UpContext or DownContext.
+func RegisterContext(up, down MigrationContextFunc) error {
+	return internalRegister(Migration{UpContext: up, DownContext: down}, 2)
+}
+
+// MustRegisterContext acts like RegisterContext but panics on errors.
+func MustRegisterContext(up, down MigrationContextFunc) {
+	if err := internalRegister(Migration{UpContext: up, DownContext: down}, 2); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterWithChecksum acts like Register but uses provided checksum instead of checksum of source file.
+// It`s useful when binary runs without migration sources.
+func RegisterWithChecksum(checksum string, up, down MigrationFunc) error {
+	return internalRegister(Migration{Up: up, Down: down, Checksum: checksum}, 2)
+}
+
+// MustRegisterWithChecksum acts like RegisterWithChecksum but panics on errors.
+func MustRegisterWithChecksum(checksum string, up, down MigrationFunc) {
+	if err := internalRegister(Migration{Up: up, Down: down, Checksum: checksum}, 2); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterWithOptions acts like RegisterContext but also sets migration options.
+func RegisterWithOptions(up, down MigrationContextFunc, opts MigrationOptions) error {
+	return internalRegister(Migration{UpContext: up, DownContext: down, Options: opts}, 2)
+}
+
+// MustRegisterWithOptions acts like RegisterWithOptions but panics on errors.
+func MustRegisterWithOptions(up, down MigrationContextFunc, opts MigrationOptions) {
+	if err := internalRegister(Migration{UpContext: up, DownContext: down, Options: opts}, 2); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterOperations registers migration which performs declarative operations.
+// Its "down" migration reverts operations in reverse order, see NewOperationMigration.
+func RegisterOperations(ops ...Operation) error {
+	return internalRegister(NewOperationMigration(0, "", ops...), 2)
+}
+
+// MustRegisterOperations acts like RegisterOperations but panics on errors.
+func MustRegisterOperations(ops ...Operation) {
+	if err := internalRegister(NewOperationMigration(0, "", ops...), 2); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterBackfill registers migration which performs resumable backfill, see NewBackfillMigration.
+func RegisterBackfill(backfill Backfill) error {
+	return internalRegister(NewBackfillMigration(0, "", backfill), 2)
+}
+
+// MustRegisterBackfill acts like RegisterBackfill but panics on errors.
+func MustRegisterBackfill(backfill Backfill) {
+	if err := internalRegister(NewBackfillMigration(0, "", backfill), 2); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterMigrations registers already built migrations, e.g. loaded by ReadDir.
+// Unlike other registration functions versions of migrations are not taken from file name of caller.
+func RegisterMigrations(migrations ...Migration) error {
+	return globalMigrate.AddMigrations(migrations...)
+}
+
+// MustRegisterMigrations acts like RegisterMigrations but panics on errors.
+func MustRegisterMigrations(migrations ...Migration) {
+	if err := globalMigrate.AddMigrations(migrations...); err != nil {
 		panic(err)
 	}
 }
@@ -91,6 +181,13 @@ func RegisteredMigrations() []Migration {
 	return ret
 }
 
+// Validate checks dependencies of globally registered migrations.
+// Call it after all migrations are registered, e.g. in main function.
+// Detailed description available in Migrate.Validate().
+func Validate() error {
+	return globalMigrate.Validate()
+}
+
 // SetDatabase sets database for global migrate.
 func SetDatabase(name string, db *mongo.Client) {
 	globalMigrate.dbName = name
@@ -102,28 +199,181 @@ func SetMigrationsCollection(name string) {
 	globalMigrate.SetMigrationsCollection(name)
 }
 
+// SetNamespace sets namespace of globally registered migrations.
+// Detailed description available in Migrate.SetNamespace().
+func SetNamespace(namespace string) {
+	globalMigrate.SetNamespace(namespace)
+}
+
 // SetLogger set a logger
 func SetLogger(l *log.Logger) {
 	globalMigrate.SetLogger(l)
 }
 
+// SetStructuredLogger set a structured logger
+func SetStructuredLogger(l Logger) {
+	globalMigrate.SetStructuredLogger(l)
+}
+
+// AddListener registers listener of migration events for global migrate.
+func AddListener(l Listener) {
+	globalMigrate.AddListener(l)
+}
+
+// SetTransactional enables transactional mode for global migrate.
+// Detailed description available in Migrate.SetTransactional().
+func SetTransactional(transactional bool) {
+	globalMigrate.SetTransactional(transactional)
+}
+
+// SetOutOfOrderPolicy sets how global migrate handles pending migrations older than latest applied one.
+func SetOutOfOrderPolicy(policy OutOfOrderPolicy) {
+	globalMigrate.SetOutOfOrderPolicy(policy)
+}
+
+// SetRetryPolicy sets retry policy for transient errors of global migrate.
+// Detailed description available in Migrate.SetRetryPolicy().
+func SetRetryPolicy(policy RetryPolicy) {
+	globalMigrate.SetRetryPolicy(policy)
+}
+
+// SetProgressInterval sets how often progress of running migration is logged.
+// Detailed description available in Migrate.SetProgressInterval().
+func SetProgressInterval(interval time.Duration) {
+	globalMigrate.SetProgressInterval(interval)
+}
+
+// SetRestoreOnFailure enables restore of collections from snapshots if migration fails.
+// Detailed description available in Migrate.SetRestoreOnFailure().
+func SetRestoreOnFailure(restore bool) {
+	globalMigrate.SetRestoreOnFailure(restore)
+}
+
+// SetLockTimeout sets how long global migrate waits for a lock held by another process.
+func SetLockTimeout(timeout time.Duration) {
+	globalMigrate.SetLockTimeout(timeout)
+}
+
+// SetLockTTL sets how long lock of global migrate stays valid without heartbeat.
+func SetLockTTL(ttl time.Duration) {
+	globalMigrate.SetLockTTL(ttl)
+}
+
+// SetLockOwner replaces lock owner identifier of global migrate.
+func SetLockOwner(owner string) {
+	globalMigrate.SetLockOwner(owner)
+}
+
 // Version returns current database version.
 func Version() (uint64, string, error) {
 	return globalMigrate.Version()
 }
 
+// VersionContext returns current database version using provided context.
+func VersionContext(ctx context.Context) (uint64, string, error) {
+	return globalMigrate.VersionContext(ctx)
+}
+
 // Up performs "up" migration using registered migrations.
 // Detailed description available in Migrate.Up().
 func Up(n int) error {
 	return globalMigrate.Up(n)
 }
 
+// UpContext performs "up" migration using registered migrations.
+// Detailed description available in Migrate.UpContext().
+func UpContext(ctx context.Context, n int) error {
+	return globalMigrate.UpContext(ctx, n)
+}
+
 // Down performs "down" migration using registered migrations.
 // Detailed description available in Migrate.Down().
 func Down(n int) error {
 	return globalMigrate.Down(n)
 }
 
+// DownContext performs "down" migration using registered migrations.
+// Detailed description available in Migrate.DownContext().
+func DownContext(ctx context.Context, n int) error {
+	return globalMigrate.DownContext(ctx, n)
+}
+
+// To performs "up" or "down" migration to provided version using registered migrations.
+// Detailed description available in Migrate.To().
+func To(version uint64) error {
+	return globalMigrate.To(version)
+}
+
+// ToContext performs "up" or "down" migration to provided version using registered migrations.
+// Detailed description available in Migrate.ToContext().
+func ToContext(ctx context.Context, version uint64) error {
+	return globalMigrate.ToContext(ctx, version)
+}
+
+// PlanUp returns plan of "up" migration using registered migrations.
+// Detailed description available in Migrate.PlanUp().
+func PlanUp(n int) (*Plan, error) {
+	return globalMigrate.PlanUp(n)
+}
+
+// PlanDown returns plan of "down" migration using registered migrations.
+// Detailed description available in Migrate.PlanDown().
+func PlanDown(n int) (*Plan, error) {
+	return globalMigrate.PlanDown(n)
+}
+
+// PlanTo returns plan of migration to provided version using registered migrations.
+// Detailed description available in Migrate.PlanTo().
+func PlanTo(version uint64) (*Plan, error) {
+	return globalMigrate.PlanTo(version)
+}
+
+// Apply performs migrations of provided plan using registered migrations.
+// Detailed description available in Migrate.Apply().
+func Apply(plan *Plan) error {
+	return globalMigrate.Apply(plan)
+}
+
+// ApplyContext performs migrations of provided plan using registered migrations.
+// Detailed description available in Migrate.ApplyContext().
+func ApplyContext(ctx context.Context, plan *Plan) error {
+	return globalMigrate.ApplyContext(ctx, plan)
+}
+
+// Status returns state of every registered or applied migration.
+// Detailed description available in Migrate.Status().
+func Status() ([]MigrationStatus, error) {
+	return globalMigrate.Status()
+}
+
+// Force clears dirty state and forcibly sets database version.
+// Detailed description available in Migrate.Force().
+func Force(version uint64) error {
+	return globalMigrate.Force(version)
+}
+
+// Repair replaces checksums of applied migrations with checksums of registered ones.
+// Detailed description available in Migrate.Repair().
+func Repair() error {
+	return globalMigrate.Repair()
+}
+
+// CleanupBackups drops snapshot collections older than retention.
+// Detailed description available in Migrate.CleanupBackups().
+func CleanupBackups(retention time.Duration) ([]string, error) {
+	return globalMigrate.CleanupBackups(retention)
+}
+
+// WriteMetrics writes DefaultMetrics in Prometheus text exposition format.
+func WriteMetrics(w io.Writer) error {
+	return DefaultMetrics.WriteMetrics(w)
+}
+
+// MetricsHandler returns http.Handler which serves DefaultMetrics in Prometheus text exposition format.
+func MetricsHandler() http.Handler {
+	return DefaultMetrics
+}
+
 func GetMigrations() []Migration {
 	return globalMigrate.migrations
 }
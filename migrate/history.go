@@ -0,0 +1,241 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// versionRecord is a state of single migration in database.
+// Migrations collection holds one record per applied version.
+//
+// Records written by previous versions of package have no "applied" field.
+// Each of them was a pointer to latest database version, they are converted on first access.
+type versionRecord struct {
+	Version     uint64
+	Namespace   string `bson:",omitempty"`
+	Description string `bson:",omitempty"`
+	Timestamp   time.Time
+	Applied     bool
+	Checksum    string `bson:",omitempty"`
+	// Dirty is set while migration is running and stays set if it failed.
+	Dirty     bool      `bson:",omitempty"`
+	Direction string    `bson:",omitempty"`
+	StartedAt time.Time `bson:"started_at,omitempty"`
+	Error     string    `bson:",omitempty"`
+	// Data holds values saved by migration, e.g. to restore state on "down" migration.
+	Data bson.Raw `bson:",omitempty"`
+}
+
+// OutOfOrderPolicy defines how "up" migration handles pending migrations
+// with versions lower than latest applied one, e.g. merged from feature branch.
+type OutOfOrderPolicy int
+
+const (
+	// OutOfOrderAllow applies out-of-order migrations as any other pending ones.
+	OutOfOrderAllow OutOfOrderPolicy = iota
+	// OutOfOrderReject fails "up" migration with *OutOfOrderError.
+	OutOfOrderReject
+)
+
+// OutOfOrderError returned if out-of-order migrations are rejected.
+type OutOfOrderError struct {
+	Versions      []uint64
+	LatestApplied uint64
+}
+
+func (e *OutOfOrderError) Error() string {
+	return fmt.Sprintf("migrations %v are older than applied version %v", e.Versions, e.LatestApplied)
+}
+
+func (m *Migrate) historyCollection() *mongo.Collection {
+	return m.db.Database(m.dbName).Collection(m.migrationsCollection)
+}
+
+// recordsFilter returns filter matching version records of migrate namespace, extra conditions are added to it.
+// Upsert with this filter stores namespace in inserted record.
+func (m *Migrate) recordsFilter(extra bson.M) bson.M {
+	filter := bson.M{"applied": bson.M{"$exists": true}}
+	if m.namespace == "" {
+		filter["namespace"] = bson.M{"$exists": false}
+	} else {
+		filter["namespace"] = m.namespace
+	}
+	for k, v := range extra {
+		filter[k] = v
+	}
+	return filter
+}
+
+// legacyRecordsFilter matches version pointers written by previous versions of package.
+func legacyRecordsFilter() bson.M {
+	return bson.M{"applied": bson.M{"$exists": false}}
+}
+
+// history returns records of all applied migrations sorted by version.
+func (m *Migrate) history(ctx context.Context) ([]versionRecord, error) {
+	if err := m.createCollectionIfNotExist(ctx, m.migrationsCollection); err != nil {
+		return nil, err
+	}
+	if err := m.upgradeLegacyHistory(ctx); err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+	res, err := m.historyCollection().Find(ctx, m.recordsFilter(bson.M{"applied": true}), findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var recs []versionRecord
+	if err := res.All(ctx, &recs); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// appliedVersions returns set of applied versions.
+func appliedVersions(recs []versionRecord) map[uint64]bool {
+	applied := make(map[uint64]bool, len(recs))
+	for _, rec := range recs {
+		applied[rec.Version] = true
+	}
+	return applied
+}
+
+// upgradeLegacyHistory converts "latest version" pointers to per-version records.
+// All registered migrations up to pointed version are considered applied.
+// Legacy history belongs to default namespace, so it`s not touched by other namespaces.
+func (m *Migrate) upgradeLegacyHistory(ctx context.Context) error {
+	if m.namespace != "" {
+		return nil
+	}
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})
+	var latest versionRecord
+	err := m.historyCollection().FindOne(ctx, legacyRecordsFilter(), findOptions).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if latest.Version > 0 {
+		if err := m.markAppliedUpTo(ctx, latest.Version, latest.Description, latest.Timestamp); err != nil {
+			return err
+		}
+	}
+	if _, err := m.historyCollection().DeleteMany(ctx, legacyRecordsFilter()); err != nil {
+		return err
+	}
+	m.log().Info("upgraded migrations history", "version", latest.Version)
+	return nil
+}
+
+// markApplied stores record of applied migration.
+func (m *Migrate) markApplied(ctx context.Context, migration Migration) error {
+	_, err := m.historyCollection().UpdateOne(ctx,
+		m.recordsFilter(bson.M{"version": migration.Version}),
+		bson.M{
+			"$set": bson.M{
+				"description": migration.Description,
+				"timestamp":   time.Now().UTC(),
+				"applied":     true,
+				"checksum":    migration.Checksum,
+			},
+			"$unset": bson.M{"dirty": "", "direction": "", "started_at": "", "error": ""},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// saveRecordData stores value in data of migration record.
+func (m *Migrate) saveRecordData(ctx context.Context, version uint64, key string, value interface{}) error {
+	_, err := m.historyCollection().UpdateOne(ctx,
+		m.recordsFilter(bson.M{"version": version}),
+		bson.M{
+			"$set":         bson.M{"data." + key: value},
+			"$setOnInsert": bson.M{"applied": false},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// loadRecordData decodes value from data of migration record. It returns false if value is not stored.
+func (m *Migrate) loadRecordData(ctx context.Context, version uint64, key string, value interface{}) (bool, error) {
+	var rec versionRecord
+	err := m.historyCollection().FindOne(ctx, m.recordsFilter(bson.M{"version": version})).Decode(&rec)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	raw := rec.Data.Lookup(key)
+	if raw.Type == 0 {
+		return false, nil
+	}
+	return true, raw.Unmarshal(value)
+}
+
+// markReverted removes record of reverted migration.
+func (m *Migrate) markReverted(ctx context.Context, version uint64) error {
+	_, err := m.historyCollection().DeleteMany(ctx, m.recordsFilter(bson.M{"version": version}))
+	if err != nil {
+		return err
+	}
+	// undo log is already replayed and cleared unless migration has own "down" callback
+	return m.clearUndo(ctx, version)
+}
+
+// markAppliedUpTo stores provided version as applied along with all registered migrations with lower versions.
+// Records of already applied migrations are kept as is.
+func (m *Migrate) markAppliedUpTo(ctx context.Context, version uint64, description string, timestamp time.Time) error {
+	versions := map[uint64]Migration{version: {Version: version, Description: description}}
+	for _, migration := range m.migrations {
+		if migration.Version < version {
+			versions[migration.Version] = migration
+		} else if migration.Version == version {
+			versions[version] = Migration{Version: version, Description: description, Checksum: migration.Checksum}
+		}
+	}
+	for v, migration := range versions {
+		set := bson.M{"description": migration.Description, "timestamp": timestamp, "applied": true, "checksum": migration.Checksum}
+		update := bson.M{"$setOnInsert": set}
+		if v == version {
+			update = bson.M{"$set": set}
+		}
+		_, err := m.historyCollection().UpdateOne(ctx, m.recordsFilter(bson.M{"version": v}), update, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingMigrations returns registered migrations which are not applied yet in order of dependencies.
+func pendingMigrations(migrations []Migration, applied map[uint64]bool) []Migration {
+	var pending []Migration
+	for _, migration := range migrations {
+		if !applied[migration.Version] && migration.up() != nil {
+			pending = append(pending, migration)
+		}
+	}
+	return dependencyOrder(pending)
+}
+
+// outOfOrderVersions returns versions of pending migrations older than latest applied one.
+func outOfOrderVersions(pending []Migration, latestApplied uint64) []uint64 {
+	var versions []uint64
+	for _, migration := range pending {
+		if migration.Version < latestApplied {
+			versions = append(versions, migration.Version)
+		}
+	}
+	return versions
+}
@@ -0,0 +1,52 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestPendingMigrations(t *testing.T) {
+	noop := func(db *mongo.Client) error { return nil }
+	migrations := []Migration{
+		{Version: 10, Description: "10", Up: noop},
+		{Version: 2, Description: "2", Up: noop},
+		{Version: 4, Description: "4"},
+		{Version: 8, Description: "8", Up: noop},
+		{Version: 6, Description: "6", Up: noop},
+	}
+	pending := pendingMigrations(migrations, map[uint64]bool{2: true, 8: true})
+	var versions []uint64
+	for _, migration := range pending {
+		versions = append(versions, migration.Version)
+	}
+	if !reflect.DeepEqual(versions, []uint64{6, 10}) {
+		t.Errorf("Unexpected pending versions: %v", versions)
+	}
+
+	if outOfOrder := outOfOrderVersions(pending, 8); !reflect.DeepEqual(outOfOrder, []uint64{6}) {
+		t.Errorf("Unexpected out-of-order versions: %v", outOfOrder)
+	}
+	if outOfOrder := outOfOrderVersions(pending, 0); len(outOfOrder) != 0 {
+		t.Errorf("Unexpected out-of-order versions: %v", outOfOrder)
+	}
+}
+
+func TestRecordsFilterNamespace(t *testing.T) {
+	migrate := NewMigrate("db", nil)
+	expected := bson.M{"applied": bson.M{"$exists": true}, "namespace": bson.M{"$exists": false}, "version": uint64(1)}
+	if filter := migrate.recordsFilter(bson.M{"version": uint64(1)}); !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Unexpected filter: %v", filter)
+	}
+
+	migrate.SetNamespace("billing")
+	expected = bson.M{"applied": bson.M{"$exists": true}, "namespace": "billing"}
+	if filter := migrate.recordsFilter(nil); !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Unexpected filter: %v", filter)
+	}
+	if migrate.lockID() != "migrate:billing" {
+		t.Errorf("Unexpected lock id: %v", migrate.lockID())
+	}
+}
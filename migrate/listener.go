@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"context"
+	"time"
+)
+
+// Event describes migration progress for listeners.
+//
+// For BeforeAll and AfterAll Version is database version before migrations and Direction is direction of plan.
+// Duration is set for AfterMigration, OnError and AfterAll, Err is set for OnError and failed AfterAll.
+type Event struct {
+	Version     uint64
+	Description string
+	Direction   Direction
+	Duration    time.Duration
+	Err         error
+}
+
+// Listener receives notifications about migrations performed by Migrate,
+// e.g. to toggle maintenance mode, post notifications or write metrics.
+// Callbacks are called synchronously from migration process.
+type Listener interface {
+	// BeforeAll called before first migration of plan.
+	BeforeAll(ctx context.Context, e Event)
+	// BeforeMigration called before each migration.
+	BeforeMigration(ctx context.Context, e Event)
+	// AfterMigration called after each successful migration.
+	AfterMigration(ctx context.Context, e Event)
+	// OnError called after each failed migration.
+	OnError(ctx context.Context, e Event)
+	// AfterAll called when plan is performed or failed.
+	AfterAll(ctx context.Context, e Event)
+}
+
+// NopListener implements Listener doing nothing.
+// It may be embedded to implement only required callbacks.
+type NopListener struct{}
+
+func (NopListener) BeforeAll(ctx context.Context, e Event)       {}
+func (NopListener) BeforeMigration(ctx context.Context, e Event) {}
+func (NopListener) AfterMigration(ctx context.Context, e Event)  {}
+func (NopListener) OnError(ctx context.Context, e Event)         {}
+func (NopListener) AfterAll(ctx context.Context, e Event)        {}
+
+// AddListener registers listener of migration events.
+func (m *Migrate) AddListener(l Listener) {
+	m.listeners = append(m.listeners, l)
+}
+
+func (m *Migrate) notify(fn func(l Listener)) {
+	for _, l := range m.listeners {
+		fn(l)
+	}
+}
@@ -0,0 +1,260 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	lockCollectionSuffix = "_lock"
+	defaultLockID        = "migrate"
+
+	defaultLockTTL     = 30 * time.Second
+	defaultLockTimeout = time.Minute
+	lockRetryInterval  = time.Second
+	// minHeartbeatInterval prevents busy heartbeat loop if lock ttl is tiny.
+	minHeartbeatInterval = 10 * time.Millisecond
+)
+
+// lockRecord is a document which holds migration lock.
+// Lock considered free if document does not exist or it`s expired.
+type lockRecord struct {
+	ID          string    `bson:"_id"`
+	Owner       string    `bson:"owner"`
+	AcquiredAt  time.Time `bson:"acquired_at"`
+	HeartbeatAt time.Time `bson:"heartbeat_at"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+// ErrLocked returned by "up" and "down" migrations if lock is held by another owner
+// and was not released during lock timeout.
+type ErrLocked struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (e *ErrLocked) Error() string {
+	return fmt.Sprintf("migrations are locked by %q until %s", e.Owner, e.ExpiresAt.Format(time.RFC3339))
+}
+
+var errLockLost = errors.New("migration lock lost")
+
+func defaultLockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// SetLockTimeout sets how long "up" and "down" migrations wait for a lock held by another owner.
+// By default it is 1 minute. Zero timeout means fail immediately.
+func (m *Migrate) SetLockTimeout(timeout time.Duration) {
+	m.lockTimeout = timeout
+}
+
+// SetLockTTL sets how long lock stays valid without heartbeat.
+// Lock of crashed process is released after this period. By default it is 30 seconds.
+// Non-positive ttl resets it to default.
+func (m *Migrate) SetLockTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	m.lockTTL = ttl
+}
+
+// SetLockOwner replaces lock owner identifier. By default it consists hostname, pid and random suffix.
+func (m *Migrate) SetLockOwner(owner string) {
+	m.lockOwner = owner
+}
+
+// lockID returns identifier of lock document, each namespace has own lock.
+func (m *Migrate) lockID() string {
+	if m.namespace == "" {
+		return defaultLockID
+	}
+	return defaultLockID + ":" + m.namespace
+}
+
+func (m *Migrate) lockCollection() *mongo.Collection {
+	return m.db.Database(m.dbName).Collection(m.migrationsCollection + lockCollectionSuffix)
+}
+
+// tryLock makes one attempt to take lock. It returns current lock holder if lock is busy.
+func (m *Migrate) tryLock(ctx context.Context) (bool, *lockRecord, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": m.lockID(),
+		"$or": bson.A{
+			bson.M{"owner": m.lockOwner},
+			bson.M{"expires_at": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"owner":        m.lockOwner,
+		"acquired_at":  now,
+		"heartbeat_at": now,
+		"expires_at":   now.Add(m.lockTTL),
+	}}
+	_, err := m.lockCollection().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err == nil {
+		return true, nil, nil
+	}
+	if !isDuplicateKeyError(err) {
+		return false, nil, err
+	}
+
+	var holder lockRecord
+	err = m.lockCollection().FindOne(ctx, bson.M{"_id": m.lockID()}).Decode(&holder)
+	if err == mongo.ErrNoDocuments {
+		// released between attempts
+		return false, &lockRecord{}, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return false, &holder, nil
+}
+
+// migrationLock is a lock taken by this process. It`s prolonged by heartbeat until released.
+type migrationLock struct {
+	m      *Migrate
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu   sync.Mutex
+	lost bool
+}
+
+// acquireLock waits for lock during lock timeout.
+// Returned context is cancelled if lock was lost during heartbeat.
+func (m *Migrate) acquireLock(ctx context.Context) (*migrationLock, context.Context, error) {
+	deadline := time.Now().Add(m.lockTimeout)
+	for {
+		ok, holder, err := m.tryLock(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			m.log().Debug("lock acquired", "owner", m.lockOwner)
+			break
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			m.log().Error("lock timeout", "owner", holder.Owner, "expires_at", holder.ExpiresAt, "timeout", m.lockTimeout)
+			return nil, nil, &ErrLocked{Owner: holder.Owner, ExpiresAt: holder.ExpiresAt}
+		}
+		if wait > lockRetryInterval {
+			wait = lockRetryInterval
+		}
+		m.log().Info("waiting for lock", "owner", holder.Owner, "expires_at", holder.ExpiresAt, "wait", wait)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	l := &migrationLock{m: m, cancel: cancel, done: make(chan struct{})}
+	go l.heartbeat(lockCtx)
+	return l, lockCtx, nil
+}
+
+// heartbeatInterval returns how often lock is prolonged, lock survives two missed heartbeats.
+func (m *Migrate) heartbeatInterval() time.Duration {
+	interval := m.lockTTL / 3
+	if interval < minHeartbeatInterval {
+		interval = minHeartbeatInterval
+	}
+	return interval
+}
+
+func (l *migrationLock) heartbeat(ctx context.Context) {
+	defer close(l.done)
+	ticker := time.NewTicker(l.m.heartbeatInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now().UTC()
+		res, err := l.m.lockCollection().UpdateOne(ctx,
+			bson.M{"_id": l.m.lockID(), "owner": l.m.lockOwner},
+			bson.M{"$set": bson.M{"heartbeat_at": now, "expires_at": now.Add(l.m.lockTTL)}},
+		)
+		if err != nil {
+			// try again on next tick, lock is valid until it expires
+			l.m.log().Warn("lock heartbeat failed", "owner", l.m.lockOwner, "error", err)
+			continue
+		}
+		if res.MatchedCount == 0 {
+			l.m.log().Error("lock lost", "owner", l.m.lockOwner)
+			l.mu.Lock()
+			l.lost = true
+			l.mu.Unlock()
+			l.cancel()
+			return
+		}
+	}
+}
+
+// release stops heartbeat and removes lock document.
+// It returns errLockLost if lock was taken by another owner while it was held.
+func (l *migrationLock) release() error {
+	l.cancel()
+	<-l.done
+
+	l.mu.Lock()
+	lost := l.lost
+	l.mu.Unlock()
+	if lost {
+		return errLockLost
+	}
+
+	// parent context may be already cancelled, lock should be released anyway
+	ctx, cancel := context.WithTimeout(context.Background(), l.m.lockTTL)
+	defer cancel()
+	_, err := l.m.lockCollection().DeleteOne(ctx, bson.M{"_id": l.m.lockID(), "owner": l.m.lockOwner})
+	return err
+}
+
+// withLock runs fn holding migration lock.
+func (m *Migrate) withLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	l, lockCtx, err := m.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(lockCtx)
+	if releaseErr := l.release(); releaseErr == errLockLost || (releaseErr != nil && err == nil) {
+		return releaseErr
+	}
+	return err
+}
+
+func isDuplicateKeyError(err error) bool {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == 11000 {
+				return true
+			}
+		}
+	}
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) {
+		return commandError.Code == 11000
+	}
+	return false
+}
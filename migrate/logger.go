@@ -0,0 +1,196 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger is a leveled structured logger.
+// keysAndValues are alternating keys and values, e.g. "version", 1, "direction", "up".
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+// Level is a severity of log event.
+type Level int
+
+const (
+	// LevelDebug is used for detailed events like lock acquisition.
+	LevelDebug Level = iota
+	// LevelInfo is used for migration progress.
+	LevelInfo
+	// LevelWarn is used for recoverable failures.
+	LevelWarn
+	// LevelError is used for failed migrations.
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// stdLogger writes events as "LEVEL message key=value ..." lines to standard library logger.
+type stdLogger struct {
+	l   *log.Logger
+	min Level
+}
+
+// NewStdLogger returns Logger which writes info and more severe events to standard library logger.
+func NewStdLogger(l *log.Logger) Logger {
+	return NewStdLoggerLevel(l, LevelInfo)
+}
+
+// NewStdLoggerLevel returns Logger which writes events of min level and more severe ones to standard library logger.
+func NewStdLoggerLevel(l *log.Logger, min Level) Logger {
+	return &stdLogger{l: l, min: min}
+}
+
+func (s *stdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	s.print(LevelDebug, msg, keysAndValues)
+}
+
+func (s *stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	s.print(LevelInfo, msg, keysAndValues)
+}
+
+func (s *stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	s.print(LevelWarn, msg, keysAndValues)
+}
+
+func (s *stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	s.print(LevelError, msg, keysAndValues)
+}
+
+func (s *stdLogger) print(level Level, msg string, keysAndValues []interface{}) {
+	if level < s.min {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, value := keyValue(keysAndValues, i)
+		fmt.Fprintf(&b, " %s=%v", key, logValue(value))
+	}
+	s.l.Println(b.String())
+}
+
+// jsonLogger writes events as JSON lines.
+type jsonLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLogger returns Logger which writes one JSON object per line to w.
+// Each object consists "time", "level", "msg" fields followed by provided keys and values.
+func NewJSONLogger(w io.Writer) Logger {
+	return &jsonLogger{w: w}
+}
+
+func (j *jsonLogger) Debug(msg string, keysAndValues ...interface{}) {
+	j.write("debug", msg, keysAndValues)
+}
+
+func (j *jsonLogger) Info(msg string, keysAndValues ...interface{}) {
+	j.write("info", msg, keysAndValues)
+}
+
+func (j *jsonLogger) Warn(msg string, keysAndValues ...interface{}) {
+	j.write("warn", msg, keysAndValues)
+}
+
+func (j *jsonLogger) Error(msg string, keysAndValues ...interface{}) {
+	j.write("error", msg, keysAndValues)
+}
+
+func (j *jsonLogger) write(level, msg string, keysAndValues []interface{}) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	writeJSONField(&b, "time", time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteByte(',')
+	writeJSONField(&b, "level", level)
+	b.WriteByte(',')
+	writeJSONField(&b, "msg", msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, value := keyValue(keysAndValues, i)
+		b.WriteByte(',')
+		writeJSONField(&b, key, logValue(value))
+	}
+	b.WriteString("}\n")
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, _ = j.w.Write(b.Bytes())
+}
+
+func writeJSONField(b *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(k)
+	b.WriteByte(':')
+	b.Write(v)
+}
+
+// keyValue returns i-th key and value, missing value of odd pair is reported as nil.
+func keyValue(keysAndValues []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(keysAndValues[i])
+	if i+1 >= len(keysAndValues) {
+		return key, nil
+	}
+	return key, keysAndValues[i+1]
+}
+
+// logValue converts values which are not readable as is.
+func logValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// SetStructuredLogger sets a structured logger.
+func (m *Migrate) SetStructuredLogger(l Logger) {
+	m.logger = l
+}
+
+func (m *Migrate) log() Logger {
+	if m.logger == nil {
+		return nopLogger{}
+	}
+	return m.logger
+}
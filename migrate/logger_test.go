@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0))
+	logger.Info("migrated", "version", 1, "direction", DirectionUp, "duration", time.Second)
+	logger.Debug("lock acquired", "owner", "test")
+	if buf.String() != "INFO migrated version=1 direction=up duration=1s\n" {
+		t.Errorf("Unexpected output: %q", buf.String())
+	}
+
+	buf.Reset()
+	logger = NewStdLoggerLevel(log.New(&buf, "", 0), LevelDebug)
+	logger.Debug("lock acquired", "owner", "test")
+	if buf.String() != "DEBUG lock acquired owner=test\n" {
+		t.Errorf("Unexpected output: %q", buf.String())
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf)
+	logger.Error("migration failed", "version", 2, "error", errors.New("failure"), "odd")
+
+	var event map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if event["level"] != "error" || event["msg"] != "migration failed" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+	if event["version"] != float64(2) || event["error"] != "failure" {
+		t.Errorf("Unexpected fields: %v", event)
+	}
+	if v, ok := event["odd"]; !ok || v != nil {
+		t.Errorf("Unexpected odd key: %v", event)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, event["time"].(string)); err != nil {
+		t.Errorf("Unexpected time: %v", err)
+	}
+}
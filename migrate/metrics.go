@@ -0,0 +1,262 @@
+package migrate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsNamespace = "mongodb_migrate"
+
+// durationBuckets are upper bounds of migration duration histogram in seconds.
+var durationBuckets = []float64{0.1, 1, 10, 60, 300, 1800, 3600}
+
+const (
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+	outcomeTimeout = "timeout"
+)
+
+type migrationsKey struct {
+	target    string
+	database  string
+	namespace string
+	direction string
+	outcome   string
+}
+
+type migrationKey struct {
+	target    string
+	database  string
+	namespace string
+	version   uint64
+	direction string
+}
+
+type durationHistogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type versionKey struct {
+	target    string
+	database  string
+	namespace string
+}
+
+type lastMigration struct {
+	duration float64
+	outcome  string
+}
+
+// Metrics collects duration and outcome of performed migrations and current schema versions.
+// It exports them in Prometheus text exposition format.
+type Metrics struct {
+	mu         sync.Mutex
+	durations  map[migrationsKey]*durationHistogram
+	migrations map[migrationKey]lastMigration
+	versions   map[versionKey]uint64
+}
+
+// NewMetrics returns empty metrics registry.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		durations:  make(map[migrationsKey]*durationHistogram),
+		migrations: make(map[migrationKey]lastMigration),
+		versions:   make(map[versionKey]uint64),
+	}
+}
+
+// DefaultMetrics is a registry used by Migrate unless other one provided with SetMetrics.
+var DefaultMetrics = NewMetrics()
+
+// SetMetrics replaces metrics registry. Nil disables metrics.
+func (m *Migrate) SetMetrics(metrics *Metrics) {
+	m.metrics = metrics
+}
+
+// SetMetricsTarget sets "target" label of metrics, e.g. name of cluster,
+// so databases with the same name on different clusters have own series. Fleet sets it to target name.
+func (m *Migrate) SetMetricsTarget(target string) {
+	m.metricsTarget = target
+}
+
+func (r *Metrics) observeMigration(target, database, namespace string, version uint64, direction Direction, duration time.Duration, err error) {
+	if r == nil {
+		return
+	}
+	outcome := outcomeSuccess
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		outcome = outcomeTimeout
+	} else if err != nil {
+		outcome = outcomeError
+	}
+	seconds := duration.Seconds()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := migrationsKey{target: target, database: database, namespace: namespace, direction: direction.String(), outcome: outcome}
+	h, ok := r.durations[key]
+	if !ok {
+		h = &durationHistogram{buckets: make([]uint64, len(durationBuckets))}
+		r.durations[key] = h
+	}
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+	r.migrations[migrationKey{target: target, database: database, namespace: namespace, version: version, direction: direction.String()}] = lastMigration{
+		duration: seconds,
+		outcome:  outcome,
+	}
+}
+
+func (r *Metrics) observeVersion(target, database, namespace string, version uint64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.versions[versionKey{target: target, database: database, namespace: namespace}] = version
+}
+
+// WriteMetrics writes metrics in Prometheus text exposition format.
+func (r *Metrics) WriteMetrics(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	name := metricsNamespace + "_migrations_total"
+	writeHeader(bw, name, "counter", "Number of performed migrations.")
+	keys := r.sortedDurationKeys()
+	for _, key := range keys {
+		fmt.Fprintf(bw, "%s{%s} %d\n", name, labels("target", key.target, "database", key.database, "namespace", key.namespace, "direction", key.direction, "outcome", key.outcome), r.durations[key].count)
+	}
+
+	name = metricsNamespace + "_migration_duration_seconds"
+	writeHeader(bw, name, "histogram", "Duration of performed migrations.")
+	for _, key := range keys {
+		h := r.durations[key]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(bw, "%s_bucket{%s} %d\n", name,
+				labels("target", key.target, "database", key.database, "namespace", key.namespace, "direction", key.direction, "outcome", key.outcome, "le", formatFloat(bound)), h.buckets[i])
+		}
+		fmt.Fprintf(bw, "%s_bucket{%s} %d\n", name,
+			labels("target", key.target, "database", key.database, "namespace", key.namespace, "direction", key.direction, "outcome", key.outcome, "le", "+Inf"), h.count)
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", name, labels("target", key.target, "database", key.database, "namespace", key.namespace, "direction", key.direction, "outcome", key.outcome), formatFloat(h.sum))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", name, labels("target", key.target, "database", key.database, "namespace", key.namespace, "direction", key.direction, "outcome", key.outcome), h.count)
+	}
+
+	name = metricsNamespace + "_last_migration_duration_seconds"
+	writeHeader(bw, name, "gauge", "Duration of latest run of each migration.")
+	migrationKeys := make([]migrationKey, 0, len(r.migrations))
+	for key := range r.migrations {
+		migrationKeys = append(migrationKeys, key)
+	}
+	sort.Slice(migrationKeys, func(i, j int) bool {
+		a, b := migrationKeys[i], migrationKeys[j]
+		if a.target != b.target {
+			return a.target < b.target
+		}
+		if a.database != b.database {
+			return a.database < b.database
+		}
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		if a.version != b.version {
+			return a.version < b.version
+		}
+		return a.direction < b.direction
+	})
+	for _, key := range migrationKeys {
+		last := r.migrations[key]
+		fmt.Fprintf(bw, "%s{%s} %s\n", name,
+			labels("target", key.target, "database", key.database, "namespace", key.namespace, "version", strconv.FormatUint(key.version, 10), "direction", key.direction, "outcome", last.outcome),
+			formatFloat(last.duration))
+	}
+
+	name = metricsNamespace + "_schema_version"
+	writeHeader(bw, name, "gauge", "Current schema version of database.")
+	versionKeys := make([]versionKey, 0, len(r.versions))
+	for key := range r.versions {
+		versionKeys = append(versionKeys, key)
+	}
+	sort.Slice(versionKeys, func(i, j int) bool {
+		a, b := versionKeys[i], versionKeys[j]
+		if a.target != b.target {
+			return a.target < b.target
+		}
+		if a.database != b.database {
+			return a.database < b.database
+		}
+		return a.namespace < b.namespace
+	})
+	for _, key := range versionKeys {
+		fmt.Fprintf(bw, "%s{%s} %d\n", name, labels("target", key.target, "database", key.database, "namespace", key.namespace), r.versions[key])
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP implements http.Handler writing metrics in Prometheus text exposition format.
+func (r *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WriteMetrics(w)
+}
+
+func (r *Metrics) sortedDurationKeys() []migrationsKey {
+	keys := make([]migrationsKey, 0, len(r.durations))
+	for key := range r.durations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.target != b.target {
+			return a.target < b.target
+		}
+		if a.database != b.database {
+			return a.database < b.database
+		}
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		if a.direction != b.direction {
+			return a.direction < b.direction
+		}
+		return a.outcome < b.outcome
+	})
+	return keys
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats alternating label names and values.
+func labels(namesAndValues ...string) string {
+	pairs := make([]string, 0, len(namesAndValues)/2)
+	for i := 0; i+1 < len(namesAndValues); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, namesAndValues[i], labelValueReplacer.Replace(namesAndValues[i+1])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,53 @@
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observeMigration("", "db", "", 1, DirectionUp, 2*time.Second, nil)
+	metrics.observeMigration("", "db", "", 2, DirectionUp, 500*time.Millisecond, errors.New("failure"))
+	metrics.observeVersion("", "db", "", 1)
+	metrics.observeVersion("eu", "db", "", 2)
+
+	var buf bytes.Buffer
+	if err := metrics.WriteMetrics(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE mongodb_migrate_migrations_total counter",
+		`mongodb_migrate_migrations_total{target="",database="db",namespace="",direction="up",outcome="success"} 1`,
+		`mongodb_migrate_migrations_total{target="",database="db",namespace="",direction="up",outcome="error"} 1`,
+		`mongodb_migrate_migration_duration_seconds_bucket{target="",database="db",namespace="",direction="up",outcome="success",le="1"} 0`,
+		`mongodb_migrate_migration_duration_seconds_bucket{target="",database="db",namespace="",direction="up",outcome="success",le="10"} 1`,
+		`mongodb_migrate_migration_duration_seconds_bucket{target="",database="db",namespace="",direction="up",outcome="success",le="+Inf"} 1`,
+		`mongodb_migrate_migration_duration_seconds_sum{target="",database="db",namespace="",direction="up",outcome="success"} 2`,
+		`mongodb_migrate_last_migration_duration_seconds{target="",database="db",namespace="",version="2",direction="up",outcome="error"} 0.5`,
+		`mongodb_migrate_schema_version{target="",database="db",namespace=""} 1`,
+		`mongodb_migrate_schema_version{target="eu",database="db",namespace=""} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Line %q not found in:\n%s", line, out)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observeVersion("", `a"b`, "", 3)
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Unexpected content type: %v", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), `mongodb_migrate_schema_version{target="",database="a\"b",namespace=""} 3`) {
+		t.Errorf("Unexpected body: %s", rec.Body.String())
+	}
+}
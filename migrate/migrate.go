@@ -3,37 +3,47 @@ package migrate
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// migrate allows to perform versioned migrations in your MongoDB.
-type versionRecord struct {
-	Version     uint64
-	Description string `bson:",omitempty"`
-	Timestamp   time.Time
-}
-
 const defaultMigrationsCollection = "migrations"
 
+const namespaceExistsErrorCode = 48
+
 // AllAvailable used in "Up" or "Down" methods to run all available migrations.
 const AllAvailable = -1
 
 // Migrate is type for performing migrations in provided database.
 // Database versioned using dedicated collection.
-// Each migration applying ("up" and "down") adds new document to collection.
+// Each applied migration has a document in collection which is removed when migration is reverted.
 // This document consists migration version, migration description and timestamp.
-// Current database version determined as version in latest added document (biggest "_id") from collection mentioned above.
+// Current database version determined as the greatest applied version.
+// "Up" and "down" migrations are performed holding a lock stored in "<collection>_lock" collection,
+// so concurrent processes do not apply the same migrations twice.
 type Migrate struct {
 	dbName               string
 	db                   *mongo.Client
 	migrations           []Migration
 	migrationsCollection string
-	logger               *log.Logger
+	namespace            string
+	logger               Logger
+	lockOwner            string
+	lockTTL              time.Duration
+	lockTimeout          time.Duration
+	transactional        bool
+	outOfOrder           OutOfOrderPolicy
+	listeners            []Listener
+	metrics              *Metrics
+	metricsTarget        string
+	retryPolicy          RetryPolicy
+	progressInterval     time.Duration
+	restoreOnFailure     bool
 }
 
 func NewMigrate(dbName string, db *mongo.Client, migrations ...Migration) *Migrate {
@@ -44,7 +54,30 @@ func NewMigrate(dbName string, db *mongo.Client, migrations ...Migration) *Migra
 		db:                   db,
 		migrations:           internalMigrations,
 		migrationsCollection: defaultMigrationsCollection,
+		lockOwner:            defaultLockOwner(),
+		lockTTL:              defaultLockTTL,
+		lockTimeout:          defaultLockTimeout,
+		metrics:              DefaultMetrics,
+		progressInterval:     defaultProgressInterval,
+	}
+}
+
+// AddMigrations adds migrations to ones provided to NewMigrate.
+// It fails if version of any migration is already used or dependencies of migrations are cyclic.
+func (m *Migrate) AddMigrations(migrations ...Migration) error {
+	all := make([]Migration, len(m.migrations), len(m.migrations)+len(migrations))
+	copy(all, m.migrations)
+	for _, migration := range migrations {
+		if hasVersion(all, migration.Version) {
+			return fmt.Errorf("migration with version %v already registered", migration.Version)
+		}
+		all = append(all, migration)
+	}
+	if _, err := sortByDependencies(all, true); err != nil {
+		return err
 	}
+	m.migrations = all
+	return nil
 }
 
 // SetMigrationsCollection replaces name of collection for storing migration information.
@@ -53,13 +86,40 @@ func (m *Migrate) SetMigrationsCollection(name string) {
 	m.migrationsCollection = name
 }
 
+// SetNamespace sets namespace of migrations, so several modules can keep their migrations in the same database.
+// Version and migrations history of each namespace are independent, migrations of a namespace are locked separately.
+// Records of default empty namespace have no namespace field, so history written before namespaces were introduced
+// belongs to it.
+func (m *Migrate) SetNamespace(namespace string) {
+	m.namespace = namespace
+}
+
+// SetTransactional enables transactional mode.
+// In this mode each migration runs inside a transaction, callback receives session context
+// and version record is committed in the same transaction. Transactions require replica set or sharded cluster.
+// Migrations with NoTransaction option run without transaction.
+func (m *Migrate) SetTransactional(transactional bool) {
+	m.transactional = transactional
+}
+
+// SetOutOfOrderPolicy sets how "up" migration handles pending migrations older than latest applied one.
+// By default they are applied.
+func (m *Migrate) SetOutOfOrderPolicy(policy OutOfOrderPolicy) {
+	m.outOfOrder = policy
+}
+
 // SetLogger set a logger
+// Standard library logger is adapted with NewStdLogger, use SetStructuredLogger for other loggers.
 func (m *Migrate) SetLogger(l *log.Logger) {
-	m.logger = l
+	if l == nil {
+		m.logger = nil
+		return
+	}
+	m.logger = NewStdLogger(l)
 }
 
-func (m *Migrate) isCollectionExist(name string) (bool, error) {
-	colls, err := m.db.Database(m.dbName).ListCollectionNames(context.Background(), bson.M{})
+func (m *Migrate) isCollectionExist(ctx context.Context, name string) (bool, error) {
+	colls, err := m.db.Database(m.dbName).ListCollectionNames(ctx, bson.M{})
 	if err != nil {
 		return false, err
 	}
@@ -71,128 +131,281 @@ func (m *Migrate) isCollectionExist(name string) (bool, error) {
 	return false, nil
 }
 
-func (m *Migrate) createCollectionIfNotExist(name string) error {
-	exist, err := m.isCollectionExist(name)
+func (m *Migrate) createCollectionIfNotExist(ctx context.Context, name string) error {
+	exist, err := m.isCollectionExist(ctx, name)
 	if err != nil {
 		return err
 	}
 	if !exist {
-		quickstartDatabase := m.db.Database(m.dbName)
-		_ = quickstartDatabase.Collection(name)
+		// collection must exist before transaction starts, it can not be created inside one on old servers
+		err := m.db.Database(m.dbName).CreateCollection(ctx, name)
+		var commandError mongo.CommandError
+		if errors.As(err, &commandError) && commandError.Code == namespaceExistsErrorCode {
+			return nil
+		}
+		return err
 	}
 	return nil
 }
 
 // Version returns current database version and comment.
 func (m *Migrate) Version() (uint64, string, error) {
-	if err := m.createCollectionIfNotExist(m.migrationsCollection); err != nil {
-		return 0, "", err
-	}
-
-	var recs []versionRecord
-	// find record with greatest id (assuming it`s latest also)
-	ctx := context.Background()
-	findOptions := options.Find()
-	// Sort by `_id` field descending
-	findOptions.SetSort(bson.D{{"_id", -1}})
-	findOptions.SetLimit(1)
-
-	res, err := m.db.Database(m.dbName).Collection("migrations").Find(ctx, bson.M{}, findOptions)
-	if err != nil {
-		return 0, "", err
-	}
+	return m.VersionContext(context.Background())
+}
 
-	err = res.All(ctx, &recs)
+// VersionContext acts like Version but uses provided context for database operations.
+func (m *Migrate) VersionContext(ctx context.Context) (uint64, string, error) {
+	recs, err := m.history(ctx)
 	if err != nil {
 		return 0, "", err
 	}
 	if len(recs) > 0 {
-		return recs[0].Version, recs[0].Description, nil
+		latest := recs[len(recs)-1]
+		m.log().Debug("database version", "version", latest.Version, "description", latest.Description)
+		m.metrics.observeVersion(m.metricsTarget, m.dbName, m.namespace, latest.Version)
+		return latest.Version, latest.Description, nil
 	}
+	m.log().Debug("database version", "version", 0)
+	m.metrics.observeVersion(m.metricsTarget, m.dbName, m.namespace, 0)
 	return 0, "", nil
 }
 
 // SetVersion forcibly changes database version to provided.
+// Provided version and all registered migrations with lower versions are marked as applied,
+// migrations with greater versions are marked as not applied.
 func (m *Migrate) SetVersion(version uint64, description string) error {
-	_collection := m.db.Database(m.dbName).Collection(m.migrationsCollection)
-	_, err := _collection.InsertOne(context.Background(), versionRecord{
-		Version:     version,
-		Timestamp:   time.Now().UTC(),
-		Description: description,
-	})
+	return m.SetVersionContext(context.Background(), version, description)
+}
+
+// SetVersionContext acts like SetVersion but uses provided context for database operations.
+func (m *Migrate) SetVersionContext(ctx context.Context, version uint64, description string) error {
+	if _, err := m.history(ctx); err != nil {
+		return err
+	}
+	_, err := m.historyCollection().DeleteMany(ctx, m.recordsFilter(bson.M{"version": bson.M{"$gt": version}}))
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := m.clearUndo(ctx, bson.M{"$gt": version}); err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+	return m.markAppliedUpTo(ctx, version, description, time.Now().UTC())
 }
 
 // Up performs "up" migrations to latest available version.
-// If n<=0 all "up" migrations with newer versions will be performed.
-// If n>0 only n migrations with newer version will be performed.
+// If n<=0 all not applied "up" migrations will be performed.
+// If n>0 only n oldest not applied migrations will be performed.
+// Not applied migrations older than latest applied one are handled according to out-of-order policy.
+// If any applied migration was changed *ChecksumMismatchError returned.
 func (m *Migrate) Up(n int) error {
-	currentVersion, _, err := m.Version()
-	if err != nil {
-		return err
-	}
-	if n <= 0 || n > len(m.migrations) {
-		n = len(m.migrations)
-	}
-	migrationSort(m.migrations)
+	return m.UpContext(context.Background(), n)
+}
 
-	for i, p := 0, 0; i < len(m.migrations) && p < n; i++ {
-		migration := m.migrations[i]
-		if migration.Version <= currentVersion || migration.Up == nil {
-			continue
+// UpContext acts like Up but passes ctx to every migration and database operation.
+// Cancelling ctx stops the process before the next migration starts.
+// If migrations are locked by another process during lock timeout *ErrLocked returned.
+func (m *Migrate) UpContext(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		plan, err := m.planUp(ctx, n)
+		if err != nil {
+			return err
+		}
+		return m.apply(ctx, plan)
+	})
+}
+
+// Down performs "down" migration to oldest available version.
+// If n<=0 all applied "down" migrations will be performed.
+// If n>0 only n newest applied migrations will be performed.
+func (m *Migrate) Down(n int) error {
+	return m.DownContext(context.Background(), n)
+}
+
+// DownContext acts like Down but passes ctx to every migration and database operation.
+// Cancelling ctx stops the process before the next migration starts.
+// If migrations are locked by another process during lock timeout *ErrLocked returned.
+func (m *Migrate) DownContext(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		plan, err := m.planDown(ctx, n)
+		if err != nil {
+			return err
+		}
+		return m.apply(ctx, plan)
+	})
+}
+
+// To performs "up" or "down" migrations to provided version.
+// Version must be one of known migration versions or 0 to revert all migrations.
+// It fails with *IrreversibleError if applied migration newer than version has no "down" callback.
+func (m *Migrate) To(version uint64) error {
+	return m.ToContext(context.Background(), version)
+}
+
+// ToContext acts like To but passes ctx to every migration and database operation.
+func (m *Migrate) ToContext(ctx context.Context, version uint64) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		plan, err := m.planTo(ctx, version)
+		if err != nil {
+			return err
 		}
-		p++
-		if err := migration.Up(m.db); err != nil {
+		return m.apply(ctx, plan)
+	})
+}
+
+// apply performs migrations of plan in order notifying listeners.
+func (m *Migrate) apply(ctx context.Context, plan *Plan) (err error) {
+	started := time.Now()
+	all := Event{Version: plan.FromVersion, Direction: plan.direction()}
+	m.log().Info("migrations started", "version", plan.FromVersion, "direction", all.Direction, "steps", len(plan.Steps))
+	m.notify(func(l Listener) { l.BeforeAll(ctx, all) })
+	defer func() {
+		all.Duration = time.Since(started)
+		all.Err = err
+		if err != nil {
+			m.log().Error("migrations failed", "direction", all.Direction, "duration", all.Duration, "error", err)
+		} else {
+			m.log().Info("migrations completed", "direction", all.Direction, "duration", all.Duration)
+		}
+		m.notify(func(l Listener) { l.AfterAll(ctx, all) })
+	}()
+
+	for _, step := range plan.Steps {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		if m.logger != nil {
-			m.logger.Printf("MIGRATED UP: %d %s\n", migration.Version, migration.Description)
+		i := migrationIndex(m.migrations, step.Version)
+		if i == -1 {
+			return fmt.Errorf("unknown migration version %v", step.Version)
 		}
-		if err := m.SetVersion(migration.Version, migration.Description); err != nil {
+		migration := m.migrations[i]
+
+		e := Event{Version: migration.Version, Description: migration.Description, Direction: step.Direction}
+		m.notify(func(l Listener) { l.BeforeMigration(ctx, e) })
+		migrationStarted := time.Now()
+		err := m.applyMigration(ctx, migration, step.Direction)
+		e.Duration = time.Since(migrationStarted)
+		m.metrics.observeMigration(m.metricsTarget, m.dbName, m.namespace, migration.Version, step.Direction, e.Duration, err)
+		if err != nil {
+			e.Err = err
+			m.log().Error("migration failed", "version", migration.Version, "description", migration.Description,
+				"direction", step.Direction, "duration", e.Duration, "error", err)
+			m.notify(func(l Listener) { l.OnError(ctx, e) })
 			return err
 		}
+		m.log().Info("migrated", "version", migration.Version, "description", migration.Description,
+			"direction", step.Direction, "duration", e.Duration)
+		m.notify(func(l Listener) { l.AfterMigration(ctx, e) })
 	}
 	return nil
 }
 
-// Down performs "down" migration to oldest available version.
-// If n<=0 all "down" migrations with older version will be performed.
-// If n>0 only n migrations with older version will be performed.
-func (m *Migrate) Down(n int) error {
-	currentVersion, _, err := m.Version()
-	if err != nil {
-		return err
+// applyMigration runs migration callback and then updates migration record.
+// Record is marked dirty while callback runs and stays dirty if it fails.
+// In transactional mode both steps are committed in one transaction, so dirty state is not tracked.
+// Transient errors are retried according to retry policy, callbacks are retried only for retryable migrations.
+// Callback run is limited by migration timeout if it`s set.
+// Collections of migration with Snapshot option are copied before "up" migration and restored if it fails,
+// record of restored migration is removed instead of being left dirty.
+func (m *Migrate) applyMigration(ctx context.Context, migration Migration, direction Direction) error {
+	callback := migration.up()
+	record := func(ctx context.Context) error {
+		return m.markApplied(ctx, migration)
 	}
-	if n <= 0 || n > len(m.migrations) {
-		n = len(m.migrations)
+	if direction == DirectionDown {
+		callback = migration.down()
+		record = func(ctx context.Context) error {
+			return m.markReverted(ctx, migration.Version)
+		}
 	}
-	migrationSort(m.migrations)
 
-	for i, p := len(m.migrations)-1, 0; i >= 0 && p < n; i-- {
-		migration := m.migrations[i]
-		if migration.Version > currentVersion || migration.Down == nil {
-			continue
+	if direction == DirectionUp && migration.Options.CaptureUndo {
+		if err := m.clearStaleUndo(ctx, migration); err != nil {
+			return err
 		}
-		p++
-		if err := migration.Down(m.db); err != nil {
+	}
+
+	var snapshots []snapshotRecord
+	if direction == DirectionUp && migration.Options.Snapshot {
+		var err error
+		if snapshots, err = m.snapshot(ctx, migration); err != nil {
 			return err
 		}
+	}
 
-		var prevMigration Migration
-		if i == 0 {
-			prevMigration = Migration{Version: 0}
-		} else {
-			prevMigration = m.migrations[i-1]
+	if !m.transactional || migration.Options.NoTransaction {
+		err := m.retry(ctx, migration.Version, true, func() error {
+			return m.markStarted(ctx, migration, direction)
+		})
+		if err != nil {
+			return err
+		}
+		err = m.retry(ctx, migration.Version, migration.Options.Retryable, func() error {
+			return m.runCallback(ctx, migration, direction, callback)
+		})
+		if err != nil && len(snapshots) > 0 && m.restoreOnFailure {
+			restoreErr := m.restoreSnapshots(snapshots)
+			if restoreErr == nil {
+				// data is back in state before migration, so migration is not dirty anymore
+				restoreErr = m.markRestored(migration.Version)
+			}
+			if restoreErr == nil {
+				return err
+			}
+			m.log().Error("failed to restore snapshots", "version", migration.Version, "error", restoreErr)
 		}
-		if m.logger != nil {
-			m.logger.Printf("MIGRATED DOWN: %d %s\n", migration.Version, migration.Description)
+		if err == nil {
+			err = m.retry(ctx, migration.Version, true, func() error {
+				return record(ctx)
+			})
 		}
-		if err := m.SetVersion(prevMigration.Version, prevMigration.Description); err != nil {
+		if err != nil {
+			if recordErr := m.markFailed(migration.Version, err); recordErr != nil {
+				m.log().Error("failed to record migration error", "version", migration.Version, "error", recordErr)
+			}
 			return err
 		}
+		return nil
 	}
-	return nil
+
+	if migration.Options.CaptureUndo {
+		// undo log is written inside transaction, so its collection must exist before it starts
+		if err := m.createCollectionIfNotExist(ctx, m.migrationsCollection+undoCollectionSuffix); err != nil {
+			return err
+		}
+	}
+
+	// transaction is aborted on any error, so it can be retried only as a whole
+	return m.retry(ctx, migration.Version, migration.Options.Retryable, func() error {
+		session, err := m.db.StartSession()
+		if err != nil {
+			return err
+		}
+		defer session.EndSession(ctx)
+		_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+			if err := m.runCallback(sessCtx, migration, direction, callback); err != nil {
+				return nil, err
+			}
+			return nil, record(sessCtx)
+		})
+		return err
+	})
+}
+
+// runCallback runs migration callback limiting it by migration timeout.
+func (m *Migrate) runCallback(ctx context.Context, migration Migration, direction Direction, callback MigrationContextFunc) error {
+	ctx = withRunInfo(ctx, &runInfo{m: m, migration: migration, direction: direction, progress: &progressTracker{}})
+	timeout := migration.Options.Timeout
+	if timeout <= 0 {
+		return callback(ctx, m.db)
+	}
+	callbackCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := callback(callbackCtx, m.db)
+	if err != nil && ctx.Err() == nil && callbackCtx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Version: migration.Version, Timeout: timeout, Err: err}
+	}
+	return err
 }
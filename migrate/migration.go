@@ -1,13 +1,20 @@
 package migrate
 
 import (
+	"context"
+	"fmt"
 	"sort"
+	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type MigrationFunc func(db *mongo.Client) error
 
+// MigrationContextFunc is a migration callback which receives the context of the running migration.
+// Implementations should stop and return as soon as ctx is done.
+type MigrationContextFunc func(ctx context.Context, db *mongo.Client) error
+
 // Migrate represents single database migration.
 // Migration contains:
 //
@@ -18,11 +25,101 @@ type MigrationFunc func(db *mongo.Client) error
 // - up: callback which will be called in "up" migration process
 //
 // - down: callback which will be called in "down" migration process for reverting changes
+//
+// UpContext and DownContext are context-aware variants of up and down callbacks.
+// When set they take precedence over Up and Down.
+//
+// Checksum identifies migration content. It`s stored along with applied migration
+// and verified before "up" migrations, so applied migrations can not be changed silently.
+// Registered migrations get checksum of their source file unless it`s provided explicitly.
+//
+// Options configure how migration is performed.
 type Migration struct {
 	Version     uint64
 	Description string
 	Up          MigrationFunc
 	Down        MigrationFunc
+	UpContext   MigrationContextFunc
+	DownContext MigrationContextFunc
+	Checksum    string
+	Options     MigrationOptions
+}
+
+// MigrationOptions configures how migration is performed.
+type MigrationOptions struct {
+	// Timeout limits run of migration callback, context passed to callback is cancelled after it.
+	// Zero means no limit.
+	Timeout time.Duration
+	// NoTransaction excludes migration from transactional mode (see Migrate.SetTransactional),
+	// it`s required for operations which can not run inside transaction, e.g. index builds.
+	NoTransaction bool
+	// Retryable marks migration safe to run several times, so its callbacks are retried on transient errors
+	// (see Migrate.SetRetryPolicy).
+	Retryable bool
+	// Resumable marks migration which continues its own work when performed again, e.g. backfill.
+	// Dirty state left by failed "up" migration does not block next runs, migration is performed again instead.
+	// Failed "down" migration blocks runs as usual.
+	Resumable bool
+	// Tags are free-form labels of migration.
+	Tags []string
+	// Collections lists collections changed by migration.
+	// Estimated number of their documents is a default total of ReportProgress.
+	Collections []string
+	// Snapshot makes copies of Collections with their indexes before "up" migration,
+	// see Migrate.SetRestoreOnFailure and Migrate.CleanupBackups.
+	Snapshot bool
+	// CaptureUndo records changes made by "up" migration through TrackCollection into undo log.
+	// Migration without "down" callback is reverted by replaying undo log.
+	CaptureUndo bool
+	// DependsOn lists versions of migrations which must be applied before this one.
+	// Migrations are applied in topological order of dependencies and reverted in reverse one,
+	// independent migrations are ordered by version.
+	DependsOn []uint64
+}
+
+// TimeoutError returned if migration callback did not complete within migration timeout.
+type TimeoutError struct {
+	Version uint64
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("migration %v timed out after %s: %v", e.Version, e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// withContext adapts MigrationFunc to MigrationContextFunc. Context is ignored by adapted function.
+func (f MigrationFunc) withContext() MigrationContextFunc {
+	if f == nil {
+		return nil
+	}
+	return func(_ context.Context, db *mongo.Client) error {
+		return f(db)
+	}
+}
+
+// up returns "up" callback of migration or nil if migration has no one.
+func (m Migration) up() MigrationContextFunc {
+	if m.UpContext != nil {
+		return m.UpContext
+	}
+	return m.Up.withContext()
+}
+
+// down returns "down" callback of migration or nil if migration has no one.
+// Migration capturing undo log without own "down" callback is reverted by undo log.
+func (m Migration) down() MigrationContextFunc {
+	if m.DownContext != nil {
+		return m.DownContext
+	}
+	if m.Down == nil && m.Options.CaptureUndo {
+		return undoDown
+	}
+	return m.Down.withContext()
 }
 
 func migrationSort(migrations []Migration) {
@@ -31,6 +128,15 @@ func migrationSort(migrations []Migration) {
 	})
 }
 
+func migrationIndex(migrations []Migration, version uint64) int {
+	for i, m := range migrations {
+		if m.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
 func hasVersion(migrations []Migration, version uint64) bool {
 	for _, m := range migrations {
 		if m.Version == version {
@@ -2,12 +2,17 @@ package migrate
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -450,3 +455,1162 @@ func TestPartialDownMigrations(t *testing.T) {
 		return
 	}
 }
+
+func TestUpContextCancelled(t *testing.T) {
+	defer cleanup(client)
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Description: "hello", UpContext: func(ctx context.Context, db *mongo.Client) error {
+			_collection := db.Database(testDB).Collection(testCollection)
+			_, err := _collection.InsertOne(ctx, bson.M{"hello": "world"})
+			return err
+		}},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := migrate.UpContext(ctx, AllAvailable); err == nil {
+		t.Errorf("Expected error for cancelled context")
+		return
+	}
+
+	version, _, err := migrate.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 0 {
+		t.Errorf("Unexpected version: %v", version)
+		return
+	}
+}
+
+func TestLockedMigrations(t *testing.T) {
+	defer cleanup(client)
+	applied := 0
+	migrations := []Migration{
+		{Version: 1, Description: "hello", Up: func(db *mongo.Client) error {
+			applied++
+			return nil
+		}},
+	}
+
+	holder := NewMigrate(testDB, client, migrations...)
+	holder.SetLockOwner("holder")
+	l, _, err := holder.acquireLock(context.Background())
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	migrate := NewMigrate(testDB, client, migrations...)
+	migrate.SetLockTimeout(0)
+	err = migrate.Up(AllAvailable)
+	var errLocked *ErrLocked
+	if !errors.As(err, &errLocked) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if errLocked.Owner != "holder" {
+		t.Errorf("Unexpected lock owner: %v", errLocked.Owner)
+		return
+	}
+	if applied != 0 {
+		t.Errorf("Migration unexpectedly applied")
+		return
+	}
+
+	if err := l.release(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	migrate.SetLockTimeout(time.Second)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if applied != 1 {
+		t.Errorf("Unexpected applied count: %v", applied)
+		return
+	}
+}
+
+func requireReplicaSet(t *testing.T) {
+	var res bson.M
+	if err := client.Database("admin").RunCommand(context.Background(), bson.M{"isMaster": 1}).Decode(&res); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := res["setName"]; !ok {
+		t.Skip("transactions require replica set")
+	}
+}
+
+func TestTransactionalMigrationRollback(t *testing.T) {
+	requireReplicaSet(t)
+	defer cleanup(client)
+	if err := client.Database(testDB).CreateCollection(context.Background(), testCollection); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	failure := errors.New("failure")
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Description: "hello", UpContext: func(ctx context.Context, db *mongo.Client) error {
+			_collection := db.Database(testDB).Collection(testCollection)
+			if _, err := _collection.InsertOne(ctx, bson.M{"hello": "world"}); err != nil {
+				return err
+			}
+			return failure
+		}},
+	)
+	migrate.SetTransactional(true)
+	if err := migrate.Up(AllAvailable); !errors.Is(err, failure) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	version, _, err := migrate.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 0 {
+		t.Errorf("Unexpected version: %v", version)
+		return
+	}
+	_collection := client.Database(testDB).Collection(testCollection)
+	err = _collection.FindOne(context.Background(), bson.M{"hello": "world"}).Decode(&bson.M{})
+	if err != mongo.ErrNoDocuments {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+}
+
+func TestToMigrations(t *testing.T) {
+	defer cleanup(client)
+	var migrations []Migration
+	for _, version := range []uint64{1, 2, 3} {
+		version := version
+		migrations = append(migrations, Migration{
+			Version: version,
+			UpContext: func(ctx context.Context, db *mongo.Client) error {
+				_, err := db.Database(testDB).Collection(testCollection).InsertOne(ctx, bson.M{"version": version})
+				return err
+			},
+			DownContext: func(ctx context.Context, db *mongo.Client) error {
+				_, err := db.Database(testDB).Collection(testCollection).DeleteOne(ctx, bson.M{"version": version})
+				return err
+			},
+		})
+	}
+	migrate := NewMigrate(testDB, client, migrations...)
+
+	if err := migrate.To(4); err == nil {
+		t.Errorf("Expected error for unknown version")
+		return
+	}
+	for _, target := range []uint64{2, 3, 1, 0} {
+		if err := migrate.To(target); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			return
+		}
+		version, _, err := migrate.Version()
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			return
+		}
+		if version != target {
+			t.Errorf("Unexpected version: %v", version)
+			return
+		}
+		count, err := client.Database(testDB).Collection(testCollection).CountDocuments(context.Background(), bson.M{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			return
+		}
+		if uint64(count) != target {
+			t.Errorf("Unexpected documents count: %v", count)
+			return
+		}
+	}
+}
+
+func TestToIrreversibleMigrations(t *testing.T) {
+	defer cleanup(client)
+	noop := func(db *mongo.Client) error { return nil }
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Up: noop, Down: noop},
+		Migration{Version: 2, Up: noop},
+		Migration{Version: 3, Up: noop, Down: noop},
+	)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	err := migrate.To(1)
+	var irreversibleErr *IrreversibleError
+	if !errors.As(err, &irreversibleErr) {
+		t.Errorf("Expected irreversible error, got %v", err)
+		return
+	}
+	if !reflect.DeepEqual(irreversibleErr.Versions, []uint64{2}) {
+		t.Errorf("Unexpected irreversible versions: %v", irreversibleErr.Versions)
+	}
+	version, _, err := migrate.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 3 {
+		t.Errorf("Unexpected version: %v", version)
+		return
+	}
+
+	// migration 3 is applied but not registered anymore
+	migrate = NewMigrate(testDB, client,
+		Migration{Version: 1, Up: noop, Down: noop},
+		Migration{Version: 2, Up: noop, Down: noop},
+	)
+	err = migrate.To(1)
+	if !errors.As(err, &irreversibleErr) {
+		t.Errorf("Expected irreversible error, got %v", err)
+		return
+	}
+	if !reflect.DeepEqual(irreversibleErr.Versions, []uint64{3}) {
+		t.Errorf("Unexpected irreversible versions: %v", irreversibleErr.Versions)
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	defer cleanup(client)
+	noop := func(db *mongo.Client) error { return nil }
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Description: "hello", Up: noop, Down: noop},
+		Migration{Version: 2, Description: "world", Up: noop},
+		Migration{Version: 3, Description: "next", Up: noop, Down: noop},
+	)
+
+	plan, err := migrate.PlanUp(2)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	expected := []PlanStep{
+		{Version: 1, Description: "hello", Direction: DirectionUp, HasDown: true},
+		{Version: 2, Description: "world", Direction: DirectionUp, HasDown: false},
+	}
+	if plan.FromVersion != 0 || len(plan.Steps) != len(expected) {
+		t.Errorf("Unexpected plan: %+v", plan)
+		return
+	}
+	for i := range expected {
+		if plan.Steps[i] != expected[i] {
+			t.Errorf("Unexpected plan step: %+v", plan.Steps[i])
+			return
+		}
+	}
+
+	version, _, err := migrate.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 0 {
+		t.Errorf("Plan unexpectedly applied")
+		return
+	}
+
+	if err := migrate.Apply(plan); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	version, _, err = migrate.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 2 {
+		t.Errorf("Unexpected version: %v", version)
+		return
+	}
+
+	if err := migrate.Apply(plan); !errors.Is(err, ErrPlanOutdated) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+}
+
+func TestOutOfOrderMigrations(t *testing.T) {
+	defer cleanup(client)
+	var applied []uint64
+	newMigration := func(version uint64) Migration {
+		return Migration{Version: version, Up: func(db *mongo.Client) error {
+			applied = append(applied, version)
+			return nil
+		}}
+	}
+
+	if err := NewMigrate(testDB, client, newMigration(1), newMigration(3)).Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	// migration 2 merged from feature branch after 3 was applied
+	migrate := NewMigrate(testDB, client, newMigration(1), newMigration(2), newMigration(3))
+	migrate.SetOutOfOrderPolicy(OutOfOrderReject)
+	err := migrate.Up(AllAvailable)
+	var outOfOrderErr *OutOfOrderError
+	if !errors.As(err, &outOfOrderErr) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if len(outOfOrderErr.Versions) != 1 || outOfOrderErr.Versions[0] != 2 {
+		t.Errorf("Unexpected out-of-order versions: %v", outOfOrderErr.Versions)
+		return
+	}
+
+	migrate.SetOutOfOrderPolicy(OutOfOrderAllow)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if len(applied) != 3 || applied[2] != 2 {
+		t.Errorf("Unexpected applied migrations: %v", applied)
+		return
+	}
+	version, _, err := migrate.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 3 {
+		t.Errorf("Unexpected version: %v", version)
+		return
+	}
+}
+
+func TestLegacyHistoryUpgrade(t *testing.T) {
+	defer cleanup(client)
+	_collection := client.Database(testDB).Collection(defaultMigrationsCollection)
+	for _, version := range []uint64{1, 2, 1} {
+		_, err := _collection.InsertOne(context.Background(), bson.M{"version": version, "timestamp": time.Now().UTC()})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			return
+		}
+	}
+
+	var applied []uint64
+	newMigration := func(version uint64) Migration {
+		return Migration{Version: version, Up: func(db *mongo.Client) error {
+			applied = append(applied, version)
+			return nil
+		}}
+	}
+	migrate := NewMigrate(testDB, client, newMigration(1), newMigration(2), newMigration(3))
+	version, _, err := migrate.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 1 {
+		t.Errorf("Unexpected version: %v", version)
+		return
+	}
+
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if len(applied) != 2 || applied[0] != 2 || applied[1] != 3 {
+		t.Errorf("Unexpected applied migrations: %v", applied)
+		return
+	}
+	count, err := _collection.CountDocuments(context.Background(), legacyRecordsFilter())
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if count != 0 {
+		t.Errorf("Unexpected legacy records count: %v", count)
+		return
+	}
+}
+
+func TestChecksumRepair(t *testing.T) {
+	defer cleanup(client)
+	noop := func(db *mongo.Client) error { return nil }
+	if err := NewMigrate(testDB, client, Migration{Version: 1, Up: noop, Checksum: "old"}).Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Up: noop, Checksum: "new"},
+		Migration{Version: 2, Up: noop, Checksum: "next"},
+	)
+	err := migrate.Up(AllAvailable)
+	var mismatchErr *ChecksumMismatchError
+	if !errors.As(err, &mismatchErr) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if len(mismatchErr.Mismatches) != 1 || mismatchErr.Mismatches[0].Version != 1 {
+		t.Errorf("Unexpected mismatches: %v", mismatchErr.Mismatches)
+		return
+	}
+
+	if err := migrate.Repair(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+}
+
+func TestDirtyMigrationForce(t *testing.T) {
+	defer cleanup(client)
+	failure := errors.New("failure")
+	fail := true
+	noop := func(db *mongo.Client) error { return nil }
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Description: "hello", Up: noop},
+		Migration{Version: 2, Description: "world", Up: func(db *mongo.Client) error {
+			if fail {
+				return failure
+			}
+			return nil
+		}},
+	)
+	if err := migrate.Up(AllAvailable); !errors.Is(err, failure) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	fail = false
+	err := migrate.Up(AllAvailable)
+	var dirtyErr *DirtyError
+	if !errors.As(err, &dirtyErr) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if dirtyErr.Version != 2 || dirtyErr.Direction != DirectionUp || dirtyErr.Err != failure.Error() {
+		t.Errorf("Unexpected dirty state: %+v", dirtyErr)
+		return
+	}
+
+	if err := migrate.Force(1); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	version, _, err := migrate.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 2 {
+		t.Errorf("Unexpected version: %v", version)
+		return
+	}
+}
+
+func TestStatus(t *testing.T) {
+	defer cleanup(client)
+	noop := func(db *mongo.Client) error { return nil }
+	if err := NewMigrate(testDB, client,
+		Migration{Version: 1, Description: "hello", Up: noop},
+		Migration{Version: 2, Description: "removed", Up: noop},
+	).Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Description: "hello", Up: noop},
+		Migration{Version: 3, Description: "world", Up: noop},
+	)
+	statuses, err := migrate.Status()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	expected := []struct {
+		version uint64
+		state   string
+	}{{1, "applied"}, {2, "orphaned"}, {3, "pending"}}
+	if len(statuses) != len(expected) {
+		t.Errorf("Unexpected statuses: %+v", statuses)
+		return
+	}
+	for i, e := range expected {
+		if statuses[i].Version != e.version || statuses[i].State() != e.state {
+			t.Errorf("Unexpected status: %+v", statuses[i])
+			return
+		}
+	}
+	if statuses[0].AppliedAt.IsZero() || !statuses[2].AppliedAt.IsZero() {
+		t.Errorf("Unexpected applied timestamps: %+v", statuses)
+		return
+	}
+}
+
+type recordingListener struct {
+	NopListener
+	events []string
+}
+
+func (l *recordingListener) BeforeAll(ctx context.Context, e Event) {
+	l.events = append(l.events, fmt.Sprintf("before all %s %d", e.Direction, e.Version))
+}
+
+func (l *recordingListener) BeforeMigration(ctx context.Context, e Event) {
+	l.events = append(l.events, fmt.Sprintf("before %s %d", e.Direction, e.Version))
+}
+
+func (l *recordingListener) AfterMigration(ctx context.Context, e Event) {
+	l.events = append(l.events, fmt.Sprintf("after %s %d", e.Direction, e.Version))
+}
+
+func (l *recordingListener) OnError(ctx context.Context, e Event) {
+	l.events = append(l.events, fmt.Sprintf("error %s %d %v", e.Direction, e.Version, e.Err))
+}
+
+func (l *recordingListener) AfterAll(ctx context.Context, e Event) {
+	l.events = append(l.events, fmt.Sprintf("after all %s %v", e.Direction, e.Err))
+}
+
+func TestListener(t *testing.T) {
+	defer cleanup(client)
+	noop := func(db *mongo.Client) error { return nil }
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Up: noop},
+		Migration{Version: 2, Up: func(db *mongo.Client) error { return errors.New("failure") }},
+	)
+	listener := &recordingListener{}
+	migrate.AddListener(listener)
+	if err := migrate.Up(AllAvailable); err == nil {
+		t.Errorf("Expected error")
+		return
+	}
+	expected := []string{
+		"before all up 0",
+		"before up 1",
+		"after up 1",
+		"before up 2",
+		"error up 2 failure",
+		"after all up failure",
+	}
+	if !reflect.DeepEqual(listener.events, expected) {
+		t.Errorf("Unexpected events: %v", listener.events)
+		return
+	}
+}
+
+func TestMigrationTimeout(t *testing.T) {
+	defer cleanup(client)
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Description: "slow", UpContext: func(ctx context.Context, db *mongo.Client) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, Options: MigrationOptions{Timeout: 10 * time.Millisecond}},
+	)
+	err := migrate.Up(AllAvailable)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if timeoutErr.Version != 1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected timeout error: %v", timeoutErr)
+		return
+	}
+}
+
+func TestTenantMigrate(t *testing.T) {
+	tenants := []string{testDB + "_tenant_a", testDB + "_tenant_b"}
+	defer func() {
+		for _, name := range tenants {
+			_ = client.Database(name).Drop(context.Background())
+		}
+	}()
+
+	tenantMigrate := NewTenantMigrate(client,
+		Migration{Version: 1, Description: "insert", UpContext: func(ctx context.Context, db *mongo.Client) error {
+			_, err := db.Database(DatabaseFromContext(ctx)).Collection(testCollection).InsertOne(ctx, bson.M{"a": 1})
+			return err
+		}},
+	)
+	tenantMigrate.SetDatabases(tenants...)
+	tenantMigrate.SetParallelism(2)
+
+	report, err := tenantMigrate.Up(AllAvailable)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	for i, result := range report.Results {
+		if result.Database != tenants[i] || result.FromVersion != 0 || result.ToVersion != 1 || result.Err != nil {
+			t.Errorf("Unexpected result: %+v", result)
+			return
+		}
+		count, err := client.Database(result.Database).Collection(testCollection).CountDocuments(context.Background(), bson.M{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			return
+		}
+		if count != 1 {
+			t.Errorf("Unexpected documents count in %s: %d", result.Database, count)
+			return
+		}
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	defer cleanup(client)
+
+	noop := func(db *mongo.Client) error { return nil }
+	billing := NewMigrate(testDB, client,
+		Migration{Version: 1, Description: "billing 1", Up: noop},
+		Migration{Version: 2, Description: "billing 2", Up: noop},
+	)
+	billing.SetNamespace("billing")
+	users := NewMigrate(testDB, client,
+		Migration{Version: 1, Description: "users 1", Up: noop},
+	)
+
+	if err := billing.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if err := users.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	version, description, err := billing.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 2 || description != "billing 2" {
+		t.Errorf("Unexpected billing version/description %v %v", version, description)
+		return
+	}
+	version, description, err = users.Version()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version != 1 || description != "users 1" {
+		t.Errorf("Unexpected users version/description %v %v", version, description)
+		return
+	}
+
+	if err := users.Down(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version, _, err := billing.Version(); err != nil || version != 2 {
+		t.Errorf("Unexpected billing version after users down: %v %v", version, err)
+		return
+	}
+}
+
+func TestDependentMigrations(t *testing.T) {
+	defer cleanup(client)
+
+	var order []uint64
+	record := func(version uint64) MigrationFunc {
+		return func(db *mongo.Client) error {
+			order = append(order, version)
+			return nil
+		}
+	}
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Up: record(1), Down: record(1)},
+		Migration{Version: 2, Up: record(2), Down: record(2), Options: MigrationOptions{DependsOn: []uint64{3}}},
+		Migration{Version: 3, Up: record(3), Down: record(3)},
+		Migration{Version: 4, Up: record(4), Options: MigrationOptions{DependsOn: []uint64{1}}},
+	)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(order, []uint64{1, 3, 2, 4}) {
+		t.Errorf("Unexpected up order: %v", order)
+		return
+	}
+
+	// migration 4 has no down, so migration 1 can not be reverted
+	order = nil
+	err := migrate.Down(AllAvailable)
+	var unmetErr *UnmetDependencyError
+	if !errors.As(err, &unmetErr) || unmetErr.Version != 4 || unmetErr.DependsOn != 1 {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	if err := migrate.Down(2); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(order, []uint64{2, 3}) {
+		t.Errorf("Unexpected down order: %v", order)
+		return
+	}
+}
+
+func indexNames(t *testing.T, collection *mongo.Collection) map[string]bool {
+	cursor, err := collection.Indexes().List(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var specs []bson.M
+	if err := cursor.All(context.Background(), &specs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, spec := range specs {
+		names[spec["name"].(string)] = true
+	}
+	return names
+}
+
+func TestOperationMigrations(t *testing.T) {
+	defer cleanup(client)
+
+	collection := client.Database(testDB).Collection(testCollection)
+	migrate := NewMigrate(testDB, client,
+		NewOperationMigration(1, "create",
+			CreateCollection{Name: testCollection},
+			CreateIndex{Collection: testCollection, Keys: bson.D{{Key: "a", Value: 1}}, Options: options.Index().SetUnique(true)},
+		),
+		NewOperationMigration(2, "drop", DropIndex{Collection: testCollection, Name: "a_1"}),
+	)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if indexNames(t, collection)["a_1"] {
+		t.Errorf("Index must be dropped")
+		return
+	}
+
+	if err := migrate.Down(1); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if !indexNames(t, collection)["a_1"] {
+		t.Errorf("Index must be restored")
+		return
+	}
+
+	if err := migrate.Down(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if exist, err := migrate.isCollectionExist(context.Background(), testCollection); err != nil || exist {
+		t.Errorf("Collection must be dropped: %v", err)
+		return
+	}
+}
+
+func TestBackfillResume(t *testing.T) {
+	defer cleanup(client)
+
+	collection := client.Database(testDB).Collection(testCollection)
+	for i := 1; i <= 5; i++ {
+		if _, err := collection.InsertOne(context.Background(), bson.M{"_id": i, "n": i}); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			return
+		}
+	}
+
+	failure := errors.New("failure")
+	var seen []int32
+	fail := true
+	migrate := NewMigrate(testDB, client, NewBackfillMigration(1, "double", Backfill{
+		Collection: testCollection,
+		Filter:     bson.M{"n": bson.M{"$gt": 0}},
+		BatchSize:  2,
+		Transform: func(ctx context.Context, doc bson.Raw) (mongo.WriteModel, error) {
+			id := doc.Lookup("_id").Int32()
+			if id == 3 && fail {
+				return nil, failure
+			}
+			seen = append(seen, id)
+			return mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": id}).
+				SetUpdate(bson.M{"$mul": bson.M{"n": 2}}), nil
+		},
+	}))
+
+	if err := migrate.Up(AllAvailable); !errors.Is(err, failure) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	fail = false
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(seen, []int32{1, 2, 3, 4, 5}) {
+		t.Errorf("Unexpected transformed documents: %v", seen)
+		return
+	}
+	var doc struct{ N int }
+	if err := collection.FindOne(context.Background(), bson.M{"_id": 1}).Decode(&doc); err != nil || doc.N != 2 {
+		t.Errorf("Document transformed more than once: %v %v", doc.N, err)
+		return
+	}
+
+	if err := migrate.To(0); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if version, _, err := migrate.Version(); err != nil || version != 0 {
+		t.Errorf("Backfill is not reverted: %v %v", version, err)
+	}
+}
+
+func TestResumableDirtyDown(t *testing.T) {
+	defer cleanup(client)
+	failure := errors.New("failure")
+	noop := func(db *mongo.Client) error { return nil }
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, Up: noop, Down: func(db *mongo.Client) error { return failure },
+			Options: MigrationOptions{Resumable: true}},
+		Migration{Version: 2, Up: noop, Down: noop},
+	)
+	if err := migrate.Up(1); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if err := migrate.Down(AllAvailable); !errors.Is(err, failure) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	// half-reverted migration must not be treated as applied
+	var dirtyErr *DirtyError
+	if err := migrate.Up(AllAvailable); !errors.As(err, &dirtyErr) || dirtyErr.Direction != DirectionDown {
+		t.Errorf("Expected dirty error, got %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	defer cleanup(client)
+
+	collection := client.Database(testDB).Collection(testCollection)
+	if _, err := collection.InsertOne(context.Background(), bson.M{"_id": 1, "a": 1}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if _, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{Keys: bson.M{"a": 1}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	failure := errors.New("failure")
+	fail := true
+	created := testCollection + "_created"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, UpContext: func(ctx context.Context, db *mongo.Client) error {
+			if !fail {
+				return nil
+			}
+			if _, err := db.Database(DatabaseFromContext(ctx)).Collection(created).InsertOne(ctx, bson.M{}); err != nil {
+				return err
+			}
+			c := db.Database(DatabaseFromContext(ctx)).Collection(testCollection)
+			if _, err := c.DeleteMany(ctx, bson.M{}); err != nil {
+				return err
+			}
+			if _, err := c.Indexes().DropAll(ctx); err != nil {
+				return err
+			}
+			if _, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"b": 1}}); err != nil {
+				return err
+			}
+			// restore must not depend on context of cancelled run
+			cancel()
+			return failure
+		}, Options: MigrationOptions{Collections: []string{testCollection, created}, Snapshot: true}},
+	)
+	migrate.SetRestoreOnFailure(true)
+	if err := migrate.UpContext(ctx, AllAvailable); !errors.Is(err, failure) {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	if count, err := collection.CountDocuments(context.Background(), bson.M{}); err != nil || count != 1 {
+		t.Errorf("Collection is not restored: %v %v", count, err)
+		return
+	}
+	if names := indexNames(t, collection); !names["a_1"] || names["b_1"] {
+		t.Errorf("Indexes are not restored: %v", names)
+		return
+	}
+	if exist, err := migrate.isCollectionExist(context.Background(), created); err != nil || exist {
+		t.Errorf("Collection created by migration is not dropped: %v %v", exist, err)
+		return
+	}
+
+	// restored migration is not dirty, so it runs again
+	fail = false
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	dropped, err := migrate.CleanupBackups(0)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	// snapshots of both runs, missing collection has snapshot record without backup
+	if len(dropped) != 4 {
+		t.Errorf("Unexpected dropped snapshots: %v", dropped)
+		return
+	}
+}
+
+func TestUndoLog(t *testing.T) {
+	defer cleanup(client)
+
+	collection := client.Database(testDB).Collection(testCollection)
+	for i := 1; i <= 3; i++ {
+		if _, err := collection.InsertOne(context.Background(), bson.M{"_id": i, "n": i}); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			return
+		}
+	}
+
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, UpContext: func(ctx context.Context, db *mongo.Client) error {
+			c := TrackCollection(ctx, db.Database(DatabaseFromContext(ctx)).Collection(testCollection))
+			if _, err := c.InsertOne(ctx, bson.M{"_id": 4, "n": 4}); err != nil {
+				return err
+			}
+			if _, err := c.UpdateMany(ctx, bson.M{"n": bson.M{"$lte": 2}}, bson.M{"$set": bson.M{"n": 0}}); err != nil {
+				return err
+			}
+			_, err := c.DeleteOne(ctx, bson.M{"_id": 3})
+			return err
+		}, Options: MigrationOptions{CaptureUndo: true}},
+	)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if err := migrate.Down(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	cursor, err := collection.Find(context.Background(), bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	var docs []struct {
+		ID int `bson:"_id"`
+		N  int
+	}
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if len(docs) != 3 || docs[0].N != 1 || docs[1].N != 2 || docs[2].N != 3 {
+		t.Errorf("Unexpected documents after undo: %+v", docs)
+		return
+	}
+	if count, err := migrate.undoCollection().CountDocuments(context.Background(), bson.M{}); err != nil || count != 0 {
+		t.Errorf("Undo log is not cleared: %v %v", count, err)
+		return
+	}
+}
+
+func TestUndoLogBatches(t *testing.T) {
+	defer cleanup(client)
+
+	collection := client.Database(testDB).Collection(testCollection)
+	total := 2*undoBatchSize + 1
+	docs := make([]interface{}, 0, total)
+	for i := 0; i < total; i++ {
+		docs = append(docs, bson.M{"_id": i, "n": i})
+	}
+	if _, err := collection.InsertMany(context.Background(), docs); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	var modified, deleted int64
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, UpContext: func(ctx context.Context, db *mongo.Client) error {
+			c := TrackCollection(ctx, db.Database(DatabaseFromContext(ctx)).Collection(testCollection))
+			// updated documents still match filter, each of them must be updated once
+			res, err := c.UpdateMany(ctx, bson.M{"n": bson.M{"$gte": 0}}, bson.M{"$inc": bson.M{"n": total}})
+			if err != nil {
+				return err
+			}
+			modified = res.ModifiedCount
+			deleteRes, err := c.DeleteMany(ctx, bson.M{"_id": bson.M{"$gte": undoBatchSize}})
+			if err != nil {
+				return err
+			}
+			deleted = deleteRes.DeletedCount
+			return nil
+		}, Options: MigrationOptions{CaptureUndo: true}},
+	)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if modified != int64(total) || deleted != int64(total-undoBatchSize) {
+		t.Errorf("Unexpected results: modified %v, deleted %v", modified, deleted)
+		return
+	}
+	if err := migrate.Down(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	count, err := collection.CountDocuments(context.Background(), bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$n"}}})
+	if err != nil || count != int64(total) {
+		t.Errorf("Unexpected restored documents count: %v %v", count, err)
+	}
+}
+
+func TestUndoLogDuplicateInsert(t *testing.T) {
+	defer cleanup(client)
+
+	collection := client.Database(testDB).Collection(testCollection)
+	if _, err := collection.InsertOne(context.Background(), bson.M{"_id": 2, "n": 2}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, UpContext: func(ctx context.Context, db *mongo.Client) error {
+			c := TrackCollection(ctx, db.Database(DatabaseFromContext(ctx)).Collection(testCollection))
+			docs := []interface{}{bson.M{"_id": 1}, bson.M{"_id": 2}, bson.M{"_id": 3}}
+			_, err := c.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+			var bulkErr mongo.BulkWriteException
+			if !errors.As(err, &bulkErr) {
+				return fmt.Errorf("expected duplicate key error, got %v", err)
+			}
+			return nil
+		}, Options: MigrationOptions{CaptureUndo: true}},
+	)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if err := migrate.Down(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	// document existed before migration, so it must survive undo
+	var doc struct{ N int }
+	if err := collection.FindOne(context.Background(), bson.M{"_id": 2}).Decode(&doc); err != nil || doc.N != 2 {
+		t.Errorf("Existing document is deleted by undo: %v %v", doc, err)
+		return
+	}
+	if count, err := collection.CountDocuments(context.Background(), bson.M{}); err != nil || count != 1 {
+		t.Errorf("Unexpected documents count after undo: %v %v", count, err)
+	}
+}
+
+func TestUndoLogStaleRecords(t *testing.T) {
+	defer cleanup(client)
+
+	collection := client.Database(testDB).Collection(testCollection)
+	run := 0
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, UpContext: func(ctx context.Context, db *mongo.Client) error {
+			run++
+			c := TrackCollection(ctx, db.Database(DatabaseFromContext(ctx)).Collection(testCollection))
+			_, err := c.InsertOne(ctx, bson.M{"_id": run})
+			return err
+		}, Options: MigrationOptions{CaptureUndo: true}},
+	)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	// version removed without undo, document of first run is kept by user
+	if err := migrate.SetVersion(0, ""); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if count, err := migrate.undoCollection().CountDocuments(context.Background(), bson.M{}); err != nil || count != 0 {
+		t.Errorf("Undo log is not cleared by SetVersion: %v %v", count, err)
+		return
+	}
+	// record left by some earlier run must not be replayed
+	id, err := rawValue(1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stale := undoRecord{ID: primitive.NewObjectID(), Version: 1, Database: testDB, Collection: testCollection,
+		Op: undoInsert, DocumentID: id}
+	if _, err := migrate.undoCollection().InsertOne(context.Background(), stale); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if err := migrate.Down(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	var docs []bson.M
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err == nil {
+		err = cursor.All(context.Background(), &docs)
+	}
+	if err != nil || len(docs) != 1 || docs[0]["_id"] != int32(1) {
+		t.Errorf("Unexpected documents after undo: %v %v", docs, err)
+	}
+}
+
+func TestTransactionalUndoLog(t *testing.T) {
+	requireReplicaSet(t)
+	defer cleanup(client)
+	if err := client.Database(testDB).CreateCollection(context.Background(), testCollection); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	migrate := NewMigrate(testDB, client,
+		Migration{Version: 1, UpContext: func(ctx context.Context, db *mongo.Client) error {
+			c := TrackCollection(ctx, db.Database(DatabaseFromContext(ctx)).Collection(testCollection))
+			_, err := c.InsertOne(ctx, bson.M{"_id": 1})
+			return err
+		}, Options: MigrationOptions{CaptureUndo: true}},
+	)
+	migrate.SetTransactional(true)
+	if err := migrate.Up(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if err := migrate.Down(AllAvailable); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if count, err := client.Database(testDB).Collection(testCollection).CountDocuments(context.Background(), bson.M{}); err != nil || count != 0 {
+		t.Errorf("Unexpected documents count after undo: %v %v", count, err)
+	}
+}
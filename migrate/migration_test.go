@@ -1,8 +1,12 @@
 package migrate
 
 import (
+	"context"
+	"errors"
 	"sort"
 	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func TestMigrationSort(t *testing.T) {
@@ -34,3 +38,28 @@ func TestHasVersion(t *testing.T) {
 		t.Errorf("Unexpectedly found version")
 	}
 }
+
+func TestMigrationCallbacks(t *testing.T) {
+	errLegacy := errors.New("legacy")
+	errContext := errors.New("context")
+	legacy := func(db *mongo.Client) error { return errLegacy }
+	withContext := func(ctx context.Context, db *mongo.Client) error { return errContext }
+
+	if (Migration{}).up() != nil || (Migration{}).down() != nil {
+		t.Errorf("Unexpected callback for empty migration")
+	}
+	migration := Migration{Up: legacy, Down: legacy}
+	if err := migration.up()(context.Background(), nil); err != errLegacy {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := migration.down()(context.Background(), nil); err != errLegacy {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	migration = Migration{Up: legacy, Down: legacy, UpContext: withContext, DownContext: withContext}
+	if err := migration.up()(context.Background(), nil); err != errContext {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := migration.down()(context.Background(), nil); err != errContext {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
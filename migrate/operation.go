@@ -0,0 +1,294 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Operation is a declarative migration step which knows how to revert itself.
+// Operations receive database migration is performed on, see DatabaseFromContext.
+type Operation interface {
+	Up(ctx context.Context, db *mongo.Database) error
+	Down(ctx context.Context, db *mongo.Database) error
+}
+
+type operationIndexKey struct{}
+
+// NewOperationMigration returns migration which performs operations in order on "up"
+// and reverts them in reverse order on "down".
+// Operations which can not run inside transaction are common, so migration has NoTransaction option.
+func NewOperationMigration(version uint64, description string, ops ...Operation) Migration {
+	return Migration{
+		Version:     version,
+		Description: description,
+		UpContext:   operationsUp(ops),
+		DownContext: operationsDown(ops),
+		Options:     MigrationOptions{NoTransaction: true},
+	}
+}
+
+func operationsUp(ops []Operation) MigrationContextFunc {
+	return func(ctx context.Context, client *mongo.Client) error {
+		db := client.Database(DatabaseFromContext(ctx))
+		for i, op := range ops {
+			if err := op.Up(context.WithValue(ctx, operationIndexKey{}, i), db); err != nil {
+				return fmt.Errorf("operation %d: %w", i, err)
+			}
+		}
+		return nil
+	}
+}
+
+func operationsDown(ops []Operation) MigrationContextFunc {
+	return func(ctx context.Context, client *mongo.Client) error {
+		db := client.Database(DatabaseFromContext(ctx))
+		for i := len(ops) - 1; i >= 0; i-- {
+			if err := ops[i].Down(context.WithValue(ctx, operationIndexKey{}, i), db); err != nil {
+				return fmt.Errorf("operation %d: %w", i, err)
+			}
+		}
+		return nil
+	}
+}
+
+// operationDataKey returns key of data saved by current operation of migration.
+func operationDataKey(ctx context.Context) string {
+	i, _ := ctx.Value(operationIndexKey{}).(int)
+	return fmt.Sprintf("operation_%d", i)
+}
+
+func saveOperationData(ctx context.Context, value interface{}) error {
+	return saveRunData(ctx, operationDataKey(ctx), value)
+}
+
+func loadOperationData(ctx context.Context, value interface{}) error {
+	ok, err := loadRunData(ctx, operationDataKey(ctx), value)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s data saved by \"up\" migration not found", operationDataKey(ctx))
+	}
+	return nil
+}
+
+// CreateIndex creates index on "up" and drops it on "down".
+type CreateIndex struct {
+	Collection string
+	Keys       interface{}
+	Options    *options.IndexOptions
+}
+
+func (o CreateIndex) Up(ctx context.Context, db *mongo.Database) error {
+	name, err := db.Collection(o.Collection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: o.Keys, Options: o.Options})
+	if err != nil {
+		return err
+	}
+	// name is generated from keys unless provided explicitly
+	return saveOperationData(ctx, name)
+}
+
+func (o CreateIndex) Down(ctx context.Context, db *mongo.Database) error {
+	var name string
+	if o.Options != nil && o.Options.Name != nil {
+		name = *o.Options.Name
+	} else if err := loadOperationData(ctx, &name); err != nil {
+		return err
+	}
+	_, err := db.Collection(o.Collection).Indexes().DropOne(ctx, name)
+	return err
+}
+
+// DropIndex drops index on "up" and restores it from captured specification on "down".
+type DropIndex struct {
+	Collection string
+	Name       string
+}
+
+func (o DropIndex) Up(ctx context.Context, db *mongo.Database) error {
+	specs, err := indexSpecs(ctx, db.Collection(o.Collection))
+	if err != nil {
+		return err
+	}
+	var spec bson.M
+	for _, s := range specs {
+		if s["name"] == o.Name {
+			spec = s
+		}
+	}
+	if spec == nil {
+		return fmt.Errorf("index %q not found in collection %q", o.Name, o.Collection)
+	}
+	if err := saveOperationData(ctx, spec); err != nil {
+		return err
+	}
+	_, err = db.Collection(o.Collection).Indexes().DropOne(ctx, o.Name)
+	return err
+}
+
+func (o DropIndex) Down(ctx context.Context, db *mongo.Database) error {
+	var spec bson.M
+	if err := loadOperationData(ctx, &spec); err != nil {
+		return err
+	}
+	return db.RunCommand(ctx, bson.D{
+		{Key: "createIndexes", Value: o.Collection},
+		{Key: "indexes", Value: bson.A{spec}},
+	}).Err()
+}
+
+// CreateCollection creates collection on "up" and drops it on "down".
+type CreateCollection struct {
+	Name    string
+	Options *options.CreateCollectionOptions
+}
+
+func (o CreateCollection) Up(ctx context.Context, db *mongo.Database) error {
+	if o.Options == nil {
+		return db.CreateCollection(ctx, o.Name)
+	}
+	return db.CreateCollection(ctx, o.Name, o.Options)
+}
+
+func (o CreateCollection) Down(ctx context.Context, db *mongo.Database) error {
+	return db.Collection(o.Name).Drop(ctx)
+}
+
+// RenameCollection renames collection on "up" and renames it back on "down".
+type RenameCollection struct {
+	From string
+	To   string
+}
+
+func (o RenameCollection) Up(ctx context.Context, db *mongo.Database) error {
+	return renameCollection(ctx, db, o.From, o.To)
+}
+
+func (o RenameCollection) Down(ctx context.Context, db *mongo.Database) error {
+	return renameCollection(ctx, db, o.To, o.From)
+}
+
+func renameCollection(ctx context.Context, db *mongo.Database, from, to string) error {
+	return db.Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: db.Name() + "." + from},
+		{Key: "to", Value: db.Name() + "." + to},
+	}).Err()
+}
+
+// RenameField renames field in documents of collection on "up" and renames it back on "down".
+// Filter limits renamed documents, nil means all documents.
+type RenameField struct {
+	Collection string
+	From       string
+	To         string
+	Filter     bson.M
+}
+
+func (o RenameField) Up(ctx context.Context, db *mongo.Database) error {
+	return renameField(ctx, db.Collection(o.Collection), o.Filter, o.From, o.To)
+}
+
+func (o RenameField) Down(ctx context.Context, db *mongo.Database) error {
+	return renameField(ctx, db.Collection(o.Collection), o.Filter, o.To, o.From)
+}
+
+func renameField(ctx context.Context, collection *mongo.Collection, filter bson.M, from, to string) error {
+	f := bson.M{from: bson.M{"$exists": true}}
+	for k, v := range filter {
+		f[k] = v
+	}
+	_, err := collection.UpdateMany(ctx, f, bson.M{"$rename": bson.M{from: to}})
+	return err
+}
+
+// SetValidator replaces validator of collection on "up" and restores previous one on "down".
+// Empty Level and Action keep current validation level and action.
+type SetValidator struct {
+	Collection string
+	Validator  interface{}
+	Level      string
+	Action     string
+}
+
+// validatorState is a validation configuration of collection.
+type validatorState struct {
+	Validator bson.Raw `bson:"validator,omitempty"`
+	Level     string   `bson:"validationLevel,omitempty"`
+	Action    string   `bson:"validationAction,omitempty"`
+}
+
+func (o SetValidator) Up(ctx context.Context, db *mongo.Database) error {
+	cursor, err := db.ListCollections(ctx, bson.M{"name": o.Collection})
+	if err != nil {
+		return err
+	}
+	var infos []struct {
+		Options validatorState `bson:"options"`
+	}
+	if err := cursor.All(ctx, &infos); err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("collection %q not found", o.Collection)
+	}
+	if err := saveOperationData(ctx, infos[0].Options); err != nil {
+		return err
+	}
+	return setValidator(ctx, db, o.Collection, o.Validator, o.Level, o.Action)
+}
+
+func (o SetValidator) Down(ctx context.Context, db *mongo.Database) error {
+	var previous validatorState
+	if err := loadOperationData(ctx, &previous); err != nil {
+		return err
+	}
+	var validator interface{} = bson.M{}
+	if previous.Validator != nil {
+		validator = previous.Validator
+	}
+	// collection had default level and action if they were not stored
+	if previous.Level == "" && o.Level != "" {
+		previous.Level = "strict"
+	}
+	if previous.Action == "" && o.Action != "" {
+		previous.Action = "error"
+	}
+	return setValidator(ctx, db, o.Collection, validator, previous.Level, previous.Action)
+}
+
+func setValidator(ctx context.Context, db *mongo.Database, collection string, validator interface{}, level, action string) error {
+	cmd := bson.D{
+		{Key: "collMod", Value: collection},
+		{Key: "validator", Value: validator},
+	}
+	if level != "" {
+		cmd = append(cmd, bson.E{Key: "validationLevel", Value: level})
+	}
+	if action != "" {
+		cmd = append(cmd, bson.E{Key: "validationAction", Value: action})
+	}
+	return db.RunCommand(ctx, cmd).Err()
+}
+
+// CreateView creates view on "up" and drops it on "down".
+type CreateView struct {
+	Name     string
+	Source   string
+	Pipeline interface{}
+	Options  *options.CreateViewOptions
+}
+
+func (o CreateView) Up(ctx context.Context, db *mongo.Database) error {
+	if o.Options == nil {
+		return db.CreateView(ctx, o.Name, o.Source, o.Pipeline)
+	}
+	return db.CreateView(ctx, o.Name, o.Source, o.Pipeline, o.Options)
+}
+
+func (o CreateView) Down(ctx context.Context, db *mongo.Database) error {
+	return db.Collection(o.Name).Drop(ctx)
+}
@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"context"
+	"sync"
+)
+
+// forEach calls fn for indexes [0, n) running at most parallelism calls at once.
+// If stopOnError is set, no new calls are started after first failure.
+// It returns errors of calls and flags of started calls.
+func forEach(ctx context.Context, n, parallelism int, stopOnError bool, fn func(ctx context.Context, i int) error) ([]error, []bool) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	errs := make([]error, n)
+	started := make([]bool, n)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return (failed && stopOnError) || ctx.Err() != nil
+	}
+	sem := make(chan struct{}, parallelism)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		if stopped() {
+			<-sem
+			break
+		}
+		started[i] = true
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := fn(ctx, i)
+			mu.Lock()
+			errs[i] = err
+			if err != nil {
+				failed = true
+			}
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	return errs, started
+}
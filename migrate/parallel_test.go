@@ -0,0 +1,73 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEachParallelism(t *testing.T) {
+	var running, maxRunning int32
+	release := make(chan struct{})
+	done := make(chan struct{})
+	var (
+		errs    []error
+		started []bool
+	)
+	go func() {
+		defer close(done)
+		errs, started = forEach(context.Background(), 6, 2, true, func(ctx context.Context, i int) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+	}()
+	// calls are released only after both of them run at once
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&running) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+	for i := range errs {
+		if errs[i] != nil || !started[i] {
+			t.Errorf("Unexpected result of call %d: %v, started %v", i, errs[i], started[i])
+		}
+	}
+	if max := atomic.LoadInt32(&maxRunning); max != 2 {
+		t.Errorf("Unexpected max parallel calls: %d", max)
+	}
+}
+
+func TestForEachStopOnError(t *testing.T) {
+	failure := errors.New("failure")
+	fn := func(ctx context.Context, i int) error {
+		if i == 1 {
+			return failure
+		}
+		return nil
+	}
+
+	errs, started := forEach(context.Background(), 4, 1, true, fn)
+	if errs[1] != failure {
+		t.Errorf("Unexpected error: %v", errs[1])
+		return
+	}
+	if started[2] || started[3] {
+		t.Errorf("Calls after failure must not start: %v", started)
+	}
+
+	errs, started = forEach(context.Background(), 4, 1, false, fn)
+	if errs[1] != failure || !started[3] || errs[3] != nil {
+		t.Errorf("All calls must run on continue on error: %v %v", errs, started)
+	}
+}
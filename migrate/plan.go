@@ -0,0 +1,361 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Direction is a direction of migration.
+type Direction int
+
+const (
+	// DirectionUp applies migration.
+	DirectionUp Direction = iota + 1
+	// DirectionDown reverts migration.
+	DirectionDown
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionUp:
+		return "up"
+	case DirectionDown:
+		return "down"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, so marshaled plan may be loaded and applied later.
+func (d *Direction) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "up":
+		*d = DirectionUp
+	case "down":
+		*d = DirectionDown
+	default:
+		return fmt.Errorf("unknown direction %q", text)
+	}
+	return nil
+}
+
+// PlanStep describes single migration which will be performed by plan.
+type PlanStep struct {
+	Version     uint64
+	Description string
+	Direction   Direction
+	HasDown     bool
+}
+
+// Plan is an ordered list of migrations computed for database version FromVersion.
+// Plan may be inspected before applying (dry run) and then performed with Migrate.Apply.
+type Plan struct {
+	FromVersion uint64
+	Steps       []PlanStep
+}
+
+// direction returns direction of first plan step, empty plan considered "up" one.
+func (p *Plan) direction() Direction {
+	if len(p.Steps) > 0 {
+		return p.Steps[0].Direction
+	}
+	return DirectionUp
+}
+
+// ErrPlanOutdated returned by Apply if database state changed since plan was computed.
+var ErrPlanOutdated = errors.New("database version changed since plan was computed")
+
+// IrreversibleError returned by To if applied migrations newer than target version have no "down" callback
+// or are not registered, so database can not be migrated exactly to target.
+type IrreversibleError struct {
+	Versions []uint64
+	Target   uint64
+}
+
+func (e *IrreversibleError) Error() string {
+	return fmt.Sprintf("migrations %v newer than target version %v can not be reverted", e.Versions, e.Target)
+}
+
+func newPlanStep(migration Migration, direction Direction) PlanStep {
+	return PlanStep{
+		Version:     migration.Version,
+		Description: migration.Description,
+		Direction:   direction,
+		HasDown:     migration.down() != nil,
+	}
+}
+
+// PlanUp returns plan of migrations which would be performed by Up(n).
+func (m *Migrate) PlanUp(n int) (*Plan, error) {
+	return m.PlanUpContext(context.Background(), n)
+}
+
+// PlanUpContext acts like PlanUp but uses provided context for database operations.
+func (m *Migrate) PlanUpContext(ctx context.Context, n int) (*Plan, error) {
+	return m.planUp(ctx, n)
+}
+
+// PlanDown returns plan of migrations which would be performed by Down(n).
+func (m *Migrate) PlanDown(n int) (*Plan, error) {
+	return m.PlanDownContext(context.Background(), n)
+}
+
+// PlanDownContext acts like PlanDown but uses provided context for database operations.
+func (m *Migrate) PlanDownContext(ctx context.Context, n int) (*Plan, error) {
+	return m.planDown(ctx, n)
+}
+
+// PlanTo returns plan of migrations which would be performed by To(version).
+func (m *Migrate) PlanTo(version uint64) (*Plan, error) {
+	return m.PlanToContext(context.Background(), version)
+}
+
+// PlanToContext acts like PlanTo but uses provided context for database operations.
+func (m *Migrate) PlanToContext(ctx context.Context, version uint64) (*Plan, error) {
+	return m.planTo(ctx, version)
+}
+
+// Apply performs only migrations of provided plan.
+// It fails with ErrPlanOutdated if database version differs from one plan was computed for
+// or any plan step was applied or reverted since then.
+func (m *Migrate) Apply(plan *Plan) error {
+	return m.ApplyContext(context.Background(), plan)
+}
+
+// ApplyContext acts like Apply but passes ctx to every migration and database operation.
+func (m *Migrate) ApplyContext(ctx context.Context, plan *Plan) error {
+	if err := m.checkSteps(plan); err != nil {
+		return err
+	}
+	return m.withLock(ctx, func(ctx context.Context) error {
+		recs, err := m.history(ctx)
+		if err != nil {
+			return err
+		}
+		if err := m.checkDirty(ctx); err != nil {
+			return err
+		}
+		if currentVersion := latestVersion(recs); currentVersion != plan.FromVersion {
+			return fmt.Errorf("%w: plan from %v, database at %v", ErrPlanOutdated, plan.FromVersion, currentVersion)
+		}
+		applied := appliedVersions(recs)
+		for _, step := range plan.Steps {
+			if step.Direction == DirectionUp && applied[step.Version] {
+				return fmt.Errorf("%w: migration %v is already applied", ErrPlanOutdated, step.Version)
+			}
+			if step.Direction == DirectionDown && !applied[step.Version] {
+				return fmt.Errorf("%w: migration %v is not applied", ErrPlanOutdated, step.Version)
+			}
+		}
+		return m.apply(ctx, plan)
+	})
+}
+
+// checkSteps verifies that every plan step refers to known migration having callback of step direction.
+// Plan may be built by caller or loaded from text, so it`s checked before anything is performed.
+func (m *Migrate) checkSteps(plan *Plan) error {
+	for _, step := range plan.Steps {
+		i := migrationIndex(m.migrations, step.Version)
+		if i == -1 {
+			return fmt.Errorf("unknown migration version %v", step.Version)
+		}
+		migration := m.migrations[i]
+		switch step.Direction {
+		case DirectionUp:
+			if migration.up() == nil {
+				return fmt.Errorf("migration %v has no up callback", step.Version)
+			}
+		case DirectionDown:
+			if migration.down() == nil {
+				return fmt.Errorf("migration %v has no down callback", step.Version)
+			}
+		default:
+			return fmt.Errorf("migration %v has invalid direction %v", step.Version, step.Direction)
+		}
+	}
+	return nil
+}
+
+func latestVersion(recs []versionRecord) uint64 {
+	if len(recs) == 0 {
+		return 0
+	}
+	return recs[len(recs)-1].Version
+}
+
+// checkOutOfOrder applies out-of-order policy to pending migrations.
+func (m *Migrate) checkOutOfOrder(pending []Migration, latestApplied uint64) error {
+	if m.outOfOrder != OutOfOrderReject {
+		return nil
+	}
+	if versions := outOfOrderVersions(pending, latestApplied); len(versions) > 0 {
+		return &OutOfOrderError{Versions: versions, LatestApplied: latestApplied}
+	}
+	return nil
+}
+
+// logSkipped logs migrations which can not be performed in provided direction because of missing callback.
+func (m *Migrate) logSkipped(applied map[uint64]bool, direction Direction) {
+	for _, migration := range m.migrations {
+		if direction == DirectionUp && !applied[migration.Version] && migration.up() == nil {
+			m.log().Debug("skip migration without up", "version", migration.Version, "description", migration.Description)
+		}
+		if direction == DirectionDown && applied[migration.Version] && migration.down() == nil {
+			m.log().Debug("skip migration without down", "version", migration.Version, "description", migration.Description)
+		}
+	}
+}
+
+func (m *Migrate) planUp(ctx context.Context, n int) (*Plan, error) {
+	recs, err := m.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkDirty(ctx); err != nil {
+		return nil, err
+	}
+	if err := m.verifyChecksums(recs); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	currentVersion := latestVersion(recs)
+	pending := pendingMigrations(m.migrations, appliedVersions(recs))
+	if err := m.checkOutOfOrder(pending, currentVersion); err != nil {
+		return nil, err
+	}
+	if n <= 0 || n > len(pending) {
+		n = len(pending)
+	}
+	if err := checkUpDependencies(pending[:n], appliedVersions(recs)); err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{FromVersion: currentVersion}
+	for _, migration := range pending[:n] {
+		plan.Steps = append(plan.Steps, newPlanStep(migration, DirectionUp))
+	}
+	m.logSkipped(appliedVersions(recs), DirectionUp)
+	return plan, nil
+}
+
+func (m *Migrate) planDown(ctx context.Context, n int) (*Plan, error) {
+	recs, err := m.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkDirty(ctx); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	applied := appliedVersions(recs)
+	if n <= 0 || n > len(m.migrations) {
+		n = len(m.migrations)
+	}
+	ordered := dependencyOrder(m.migrations)
+
+	plan := &Plan{FromVersion: latestVersion(recs)}
+	var reverted []Migration
+	for i := len(ordered) - 1; i >= 0 && len(reverted) < n; i-- {
+		migration := ordered[i]
+		if !applied[migration.Version] || migration.down() == nil {
+			continue
+		}
+		reverted = append(reverted, migration)
+	}
+	if err := checkDownDependencies(m.migrations, reverted, applied); err != nil {
+		return nil, err
+	}
+	for _, migration := range reverted {
+		plan.Steps = append(plan.Steps, newPlanStep(migration, DirectionDown))
+	}
+	m.logSkipped(applied, DirectionDown)
+	return plan, nil
+}
+
+// planTo reverts applied migrations newer than version and then applies pending ones up to version.
+func (m *Migrate) planTo(ctx context.Context, version uint64) (*Plan, error) {
+	if version != 0 && !hasVersion(m.migrations, version) {
+		return nil, fmt.Errorf("unknown migration version %v", version)
+	}
+	recs, err := m.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkDirty(ctx); err != nil {
+		return nil, err
+	}
+	if err := m.verifyChecksums(recs); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	applied := appliedVersions(recs)
+	ordered := dependencyOrder(m.migrations)
+
+	plan := &Plan{FromVersion: latestVersion(recs)}
+	var reverted []Migration
+	var irreversible []uint64
+	kept := make(map[uint64]bool)
+	var latestKept uint64
+	for i := len(ordered) - 1; i >= 0; i-- {
+		migration := ordered[i]
+		if !applied[migration.Version] {
+			continue
+		}
+		if migration.Version > version {
+			if migration.down() == nil {
+				irreversible = append(irreversible, migration.Version)
+			}
+			reverted = append(reverted, migration)
+			continue
+		}
+		kept[migration.Version] = true
+		if migration.Version > latestKept {
+			latestKept = migration.Version
+		}
+	}
+	// applied migrations which are not registered anymore can not be reverted too
+	for v := range applied {
+		if v > version && !hasVersion(m.migrations, v) {
+			irreversible = append(irreversible, v)
+		}
+	}
+	if len(irreversible) > 0 {
+		sort.Slice(irreversible, func(i, j int) bool { return irreversible[i] < irreversible[j] })
+		return nil, &IrreversibleError{Versions: irreversible, Target: version}
+	}
+	if err := checkDownDependencies(m.migrations, reverted, applied); err != nil {
+		return nil, err
+	}
+	for _, migration := range reverted {
+		plan.Steps = append(plan.Steps, newPlanStep(migration, DirectionDown))
+	}
+
+	var pending []Migration
+	for _, migration := range pendingMigrations(m.migrations, applied) {
+		if migration.Version <= version {
+			pending = append(pending, migration)
+		}
+	}
+	if err := m.checkOutOfOrder(pending, latestKept); err != nil {
+		return nil, err
+	}
+	if err := checkUpDependencies(pending, kept); err != nil {
+		return nil, err
+	}
+	for _, migration := range pending {
+		plan.Steps = append(plan.Steps, newPlanStep(migration, DirectionUp))
+	}
+	return plan, nil
+}
@@ -0,0 +1,52 @@
+package migrate
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestPlanJSON(t *testing.T) {
+	plan := &Plan{FromVersion: 2, Steps: []PlanStep{
+		{Version: 2, Description: "world", Direction: DirectionDown, HasDown: true},
+		{Version: 3, Description: "next", Direction: DirectionUp},
+	}}
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	var loaded Plan
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(&loaded, plan) {
+		t.Errorf("Unexpected plan: %+v", loaded)
+	}
+
+	var d Direction
+	if err := d.UnmarshalText([]byte("sideways")); err == nil {
+		t.Errorf("Expected error for unknown direction")
+	}
+}
+
+func TestApplyMissingCallback(t *testing.T) {
+	noop := func(db *mongo.Client) error { return nil }
+	migrate := NewMigrate("test", nil,
+		Migration{Version: 1, Up: noop},
+		Migration{Version: 2, Down: noop},
+	)
+	for _, step := range []PlanStep{
+		{Version: 1, Direction: DirectionDown},
+		{Version: 2, Direction: DirectionUp},
+		{Version: 3, Direction: DirectionUp},
+		{Version: 1},
+	} {
+		if err := migrate.Apply(&Plan{Steps: []PlanStep{step}}); err == nil {
+			t.Errorf("Expected error for step %+v", step)
+		}
+	}
+}
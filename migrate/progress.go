@@ -0,0 +1,136 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultProgressInterval = 10 * time.Second
+
+// Progress describes progress of running migration reported with ReportProgress.
+// Total is 0 if it`s unknown. Rate is a number of processed items per second since first report,
+// ETA is 0 if it can not be estimated.
+type Progress struct {
+	Version     uint64
+	Description string
+	Processed   int64
+	Total       int64
+	Rate        float64
+	Elapsed     time.Duration
+	ETA         time.Duration
+}
+
+// Percent returns completed percentage or -1 if total is unknown.
+func (p Progress) Percent() float64 {
+	if p.Total <= 0 {
+		return -1
+	}
+	return float64(p.Processed) * 100 / float64(p.Total)
+}
+
+// ProgressListener may be implemented by Listener to receive every progress report of running migrations.
+type ProgressListener interface {
+	OnProgress(ctx context.Context, p Progress)
+}
+
+// progressTracker holds progress state of single migration run.
+type progressTracker struct {
+	mu             sync.Mutex
+	started        time.Time
+	startProcessed int64
+	total          int64
+	totalLoaded    bool
+	lastLogged     time.Time
+}
+
+// SetProgressInterval sets how often progress of running migration is logged. By default it is 10 seconds.
+// Non-positive interval resets it to default.
+func (m *Migrate) SetProgressInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	m.progressInterval = interval
+}
+
+// ReportProgress reports that running migration processed some of total items, e.g. documents.
+// Non-positive total is replaced by estimated count of documents in collections listed in Collections option.
+// Progress is logged periodically with rate and ETA and passed to listeners implementing ProgressListener.
+// Report progress before processing starts to get accurate rate of resumed migrations.
+// Call is ignored if ctx was not passed to migration callback by Migrate.
+func ReportProgress(ctx context.Context, processed, total int64) {
+	info := runInfoFromContext(ctx)
+	if info == nil || info.progress == nil {
+		return
+	}
+	info.m.reportProgress(ctx, info, processed, total)
+}
+
+func (m *Migrate) reportProgress(ctx context.Context, info *runInfo, processed, total int64) {
+	tracker := info.progress
+	tracker.mu.Lock()
+	now := time.Now()
+	if tracker.started.IsZero() {
+		tracker.started = now
+		tracker.startProcessed = processed
+		tracker.lastLogged = now
+	}
+	if total <= 0 {
+		if !tracker.totalLoaded {
+			tracker.total = m.estimateTotal(ctx, info.migration)
+			tracker.totalLoaded = true
+		}
+		total = tracker.total
+	}
+
+	p := Progress{
+		Version:     info.migration.Version,
+		Description: info.migration.Description,
+		Processed:   processed,
+		Total:       total,
+		Elapsed:     now.Sub(tracker.started),
+	}
+	if seconds := p.Elapsed.Seconds(); seconds > 0 {
+		p.Rate = float64(processed-tracker.startProcessed) / seconds
+	}
+	if p.Rate > 0 && total > processed {
+		p.ETA = time.Duration(float64(total-processed) / p.Rate * float64(time.Second))
+	}
+	logNow := now.Sub(tracker.lastLogged) >= m.progressInterval || (total > 0 && processed >= total)
+	if logNow {
+		tracker.lastLogged = now
+	}
+	tracker.mu.Unlock()
+
+	if logNow {
+		kv := []interface{}{"version", p.Version, "processed", p.Processed}
+		if p.Total > 0 {
+			kv = append(kv, "total", p.Total, "percent", fmt.Sprintf("%.1f", p.Percent()))
+		}
+		kv = append(kv, "rate", fmt.Sprintf("%.1f/s", p.Rate))
+		if p.ETA > 0 {
+			kv = append(kv, "eta", p.ETA.Round(time.Second))
+		}
+		m.log().Info("migration progress", kv...)
+	}
+	for _, l := range m.listeners {
+		if pl, ok := l.(ProgressListener); ok {
+			pl.OnProgress(ctx, p)
+		}
+	}
+}
+
+// estimateTotal returns estimated number of documents in collections of migration.
+func (m *Migrate) estimateTotal(ctx context.Context, migration Migration) int64 {
+	var total int64
+	for _, name := range migration.Options.Collections {
+		count, err := m.db.Database(m.dbName).Collection(name).EstimatedDocumentCount(ctx)
+		if err != nil {
+			m.log().Warn("failed to estimate documents count", "version", migration.Version, "collection", name, "error", err)
+			return 0
+		}
+		total += count
+	}
+	return total
+}
@@ -0,0 +1,59 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+type progressRecorder struct {
+	NopListener
+	reports []Progress
+}
+
+func (r *progressRecorder) OnProgress(ctx context.Context, p Progress) {
+	r.reports = append(r.reports, p)
+}
+
+func TestReportProgress(t *testing.T) {
+	var buf bytes.Buffer
+	migrate := NewMigrate("db", nil)
+	migrate.SetLogger(log.New(&buf, "", 0))
+	migrate.SetProgressInterval(time.Hour)
+	recorder := &progressRecorder{}
+	migrate.AddListener(recorder)
+
+	migration := Migration{Version: 7, Description: "backfill"}
+	ctx := withRunInfo(context.Background(), &runInfo{m: migrate, migration: migration, direction: DirectionUp, progress: &progressTracker{}})
+	ReportProgress(ctx, 100, 1000)
+	time.Sleep(10 * time.Millisecond)
+	ReportProgress(ctx, 500, 1000)
+	ReportProgress(ctx, 1000, 1000)
+
+	if len(recorder.reports) != 3 {
+		t.Errorf("Unexpected reports: %v", recorder.reports)
+		return
+	}
+	p := recorder.reports[1]
+	if p.Version != 7 || p.Processed != 500 || p.Total != 1000 || p.Percent() != 50 {
+		t.Errorf("Unexpected progress: %+v", p)
+	}
+	if p.Rate <= 0 || p.ETA <= 0 || p.Elapsed <= 0 {
+		t.Errorf("Unexpected rate: %+v", p)
+	}
+
+	// only completion is logged within interval
+	out := buf.String()
+	if strings.Count(out, "migration progress") != 1 || !strings.Contains(out, "processed=1000 total=1000 percent=100.0") {
+		t.Errorf("Unexpected log: %q", out)
+	}
+
+	// reports outside of migration are ignored
+	ReportProgress(context.Background(), 1, 1)
+	if len(recorder.reports) != 3 {
+		t.Errorf("Unexpected reports: %v", recorder.reports)
+	}
+}
@@ -0,0 +1,91 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Error labels which driver attaches to errors safe to retry.
+const (
+	networkErrorLabel              = "NetworkError"
+	retryableWriteErrorLabel       = "RetryableWriteError"
+	transientTransactionErrorLabel = "TransientTransactionError"
+)
+
+// RetryPolicy defines retrying of transient MongoDB errors, e.g. caused by elections or network blips.
+// Migration records are always retried, migration callbacks only if migration is retryable.
+type RetryPolicy struct {
+	// MaxAttempts is a total number of attempts, retries are disabled if it`s less than 2.
+	MaxAttempts int
+	// InitialBackoff is a delay before first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff limits delay between retries, zero means no limit.
+	MaxBackoff time.Duration
+	// Multiplier increases delay after each retry, values less than 1 are treated as 1.
+	Multiplier float64
+	// Jitter randomizes delay by provided fraction of it, e.g. 0.2 means ±20%.
+	Jitter float64
+}
+
+// SetRetryPolicy sets retry policy for transient errors. By default errors are not retried.
+func (m *Migrate) SetRetryPolicy(policy RetryPolicy) {
+	m.retryPolicy = policy
+}
+
+// IsRetryableError reports whether driver marked error as transient or retryable.
+func IsRetryableError(err error) bool {
+	var labeled interface {
+		HasErrorLabel(label string) bool
+	}
+	if !errors.As(err, &labeled) {
+		return false
+	}
+	return labeled.HasErrorLabel(networkErrorLabel) ||
+		labeled.HasErrorLabel(retryableWriteErrorLabel) ||
+		labeled.HasErrorLabel(transientTransactionErrorLabel)
+}
+
+// backoff returns delay before retry following provided attempt (starting from 1).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
+
+// retry calls fn until it succeeds, fails with not retryable error or attempts are exhausted.
+// If retryable is false fn is called once.
+func (m *Migrate) retry(ctx context.Context, version uint64, retryable bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable || attempt >= m.retryPolicy.MaxAttempts || !IsRetryableError(err) {
+			return err
+		}
+		delay := m.retryPolicy.backoff(attempt)
+		m.log().Warn("retrying transient error", "version", version, "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.New("failure"), false},
+		{mongo.CommandError{Code: 11000}, false},
+		{mongo.CommandError{Labels: []string{"NetworkError"}}, true},
+		{mongo.CommandError{Labels: []string{"TransientTransactionError"}}, true},
+		{mongo.WriteException{Labels: []string{"RetryableWriteError"}}, true},
+		{fmt.Errorf("wrapped: %w", mongo.CommandError{Labels: []string{"NetworkError"}}), true},
+	} {
+		if IsRetryableError(tc.err) != tc.retryable {
+			t.Errorf("Unexpected result for %v", tc.err)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if backoff := policy.backoff(attempt + 1); backoff != expected {
+			t.Errorf("Unexpected backoff for attempt %d: %v", attempt+1, backoff)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if backoff := policy.backoff(1); backoff < 500*time.Millisecond || backoff > 1500*time.Millisecond {
+			t.Errorf("Unexpected backoff with jitter: %v", backoff)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	m := NewMigrate("", nil)
+	m.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+	transient := mongo.CommandError{Labels: []string{"NetworkError"}}
+
+	calls := 0
+	err := m.retry(context.Background(), 1, true, func() error {
+		calls++
+		return transient
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("Unexpected result: %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = m.retry(context.Background(), 1, false, func() error {
+		calls++
+		return transient
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Unexpected result: %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = m.retry(context.Background(), 1, true, func() error {
+		calls++
+		if calls == 1 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("Unexpected result: %v after %d calls", err, calls)
+	}
+}
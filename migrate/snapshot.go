@@ -0,0 +1,213 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const snapshotCollectionSuffix = "_snapshots"
+
+// snapshotRestoreTimeout limits restore of collections after failed migration, it copies whole collections.
+const snapshotRestoreTimeout = time.Hour
+
+// snapshotRecord describes backup collection made before migration. It`s stored in "<collection>_snapshots" collection.
+type snapshotRecord struct {
+	Backup     string    `bson:"_id"`
+	Collection string    `bson:"collection"`
+	Namespace  string    `bson:"namespace,omitempty"`
+	Version    uint64    `bson:"version"`
+	CreatedAt  time.Time `bson:"created_at"`
+	Indexes    []bson.M  `bson:"indexes"`
+	// Missing means collection did not exist before migration, it`s dropped on restore and has no backup collection.
+	Missing bool `bson:"missing,omitempty"`
+}
+
+// SetRestoreOnFailure enables restore of collections from snapshots if "up" migration with Snapshot option fails.
+// Restored collections are replaced by snapshot data and indexes, snapshots are kept until CleanupBackups.
+// Restore is not needed in transactional mode, transaction is rolled back instead.
+func (m *Migrate) SetRestoreOnFailure(restore bool) {
+	m.restoreOnFailure = restore
+}
+
+func (m *Migrate) snapshotCollection() *mongo.Collection {
+	return m.db.Database(m.dbName).Collection(m.migrationsCollection + snapshotCollectionSuffix)
+}
+
+// snapshot copies collections of migration into timestamped backup collections including indexes.
+func (m *Migrate) snapshot(ctx context.Context, migration Migration) ([]snapshotRecord, error) {
+	db := m.db.Database(m.dbName)
+	now := time.Now().UTC()
+	var snapshots []snapshotRecord
+	for _, name := range migration.Options.Collections {
+		exist, err := m.isCollectionExist(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		s := snapshotRecord{
+			// milliseconds keep names of quickly repeated runs unique
+			Backup: fmt.Sprintf("%s_backup_%d_%s%03d", name, migration.Version,
+				now.Format("20060102150405"), now.Nanosecond()/int(time.Millisecond)),
+			Collection: name,
+			Namespace:  m.namespace,
+			Version:    migration.Version,
+			CreatedAt:  now,
+			Missing:    !exist,
+		}
+		if exist {
+			if s.Indexes, err = indexSpecs(ctx, db.Collection(name)); err != nil {
+				return nil, err
+			}
+			if err := copyCollection(ctx, db, name, s.Backup, s.Indexes); err != nil {
+				return nil, fmt.Errorf("snapshot of %q: %w", name, err)
+			}
+		}
+		if _, err := m.snapshotCollection().InsertOne(ctx, s); err != nil {
+			return nil, err
+		}
+		if exist {
+			m.log().Info("collection snapshot created", "version", migration.Version, "collection", name, "backup", s.Backup)
+		} else {
+			m.log().Debug("missing collection recorded in snapshot", "version", migration.Version, "collection", name)
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, nil
+}
+
+// restoreSnapshots replaces collections by their snapshots.
+// Restore runs with own timeout, context of failed migration may be already done.
+func (m *Migrate) restoreSnapshots(snapshots []snapshotRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotRestoreTimeout)
+	defer cancel()
+	for _, s := range snapshots {
+		if err := m.restoreSnapshot(ctx, s); err != nil {
+			return fmt.Errorf("restore of %q: %w", s.Collection, err)
+		}
+		m.log().Info("collection restored from snapshot", "version", s.Version, "collection", s.Collection, "backup", s.Backup)
+	}
+	return nil
+}
+
+// restoreSnapshot replaces collection by snapshot without dropping it, so failed restore keeps current data.
+func (m *Migrate) restoreSnapshot(ctx context.Context, s snapshotRecord) error {
+	db := m.db.Database(m.dbName)
+	if s.Missing {
+		// collection was created by migration
+		return db.Collection(s.Collection).Drop(ctx)
+	}
+	exist, err := m.isCollectionExist(ctx, s.Collection)
+	if err != nil {
+		return err
+	}
+	if exist {
+		// $out keeps indexes of replaced collection, so indexes created or changed by migration are dropped
+		current, err := indexSpecs(ctx, db.Collection(s.Collection))
+		if err != nil {
+			return err
+		}
+		for _, spec := range current {
+			name, _ := spec["name"].(string)
+			if name == "_id_" || hasIndexSpec(s.Indexes, spec) {
+				continue
+			}
+			if _, err := db.Collection(s.Collection).Indexes().DropOne(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
+	return copyCollection(ctx, db, s.Backup, s.Collection, s.Indexes)
+}
+
+func hasIndexSpec(specs []bson.M, spec bson.M) bool {
+	for _, s := range specs {
+		if reflect.DeepEqual(s, spec) {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanupBackups drops snapshot collections older than retention. It returns names of dropped collections.
+func (m *Migrate) CleanupBackups(retention time.Duration) ([]string, error) {
+	return m.CleanupBackupsContext(context.Background(), retention)
+}
+
+// CleanupBackupsContext acts like CleanupBackups but uses provided context for database operations.
+func (m *Migrate) CleanupBackupsContext(ctx context.Context, retention time.Duration) ([]string, error) {
+	var dropped []string
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		filter := bson.M{"created_at": bson.M{"$lt": time.Now().UTC().Add(-retention)}}
+		if m.namespace == "" {
+			filter["namespace"] = bson.M{"$exists": false}
+		} else {
+			filter["namespace"] = m.namespace
+		}
+		cursor, err := m.snapshotCollection().Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		var snapshots []snapshotRecord
+		if err := cursor.All(ctx, &snapshots); err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			if err := m.db.Database(m.dbName).Collection(s.Backup).Drop(ctx); err != nil {
+				return err
+			}
+			if _, err := m.snapshotCollection().DeleteOne(ctx, bson.M{"_id": s.Backup}); err != nil {
+				return err
+			}
+			m.log().Info("snapshot dropped", "version", s.Version, "collection", s.Collection, "backup", s.Backup)
+			dropped = append(dropped, s.Backup)
+		}
+		return nil
+	})
+	return dropped, err
+}
+
+// copyCollection copies documents of collection on server side and creates provided indexes on target.
+// Existing target collection is replaced atomically by $out, its indexes are kept.
+func copyCollection(ctx context.Context, db *mongo.Database, from, to string, indexes []bson.M) error {
+	cursor, err := db.Collection(from).Aggregate(ctx, mongo.Pipeline{{{Key: "$out", Value: to}}})
+	if err != nil {
+		return err
+	}
+	if err := cursor.Close(ctx); err != nil {
+		return err
+	}
+	var specs bson.A
+	for _, spec := range indexes {
+		if spec["name"] != "_id_" {
+			specs = append(specs, spec)
+		}
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+	return db.RunCommand(ctx, bson.D{
+		{Key: "createIndexes", Value: to},
+		{Key: "indexes", Value: specs},
+	}).Err()
+}
+
+// indexSpecs returns specifications of collection indexes which may be used to create them again.
+func indexSpecs(ctx context.Context, collection *mongo.Collection) ([]bson.M, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var specs []bson.M
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+	for _, spec := range specs {
+		delete(spec, "v")
+		delete(spec, "ns")
+	}
+	return specs, nil
+}
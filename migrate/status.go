@@ -0,0 +1,87 @@
+package migrate
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrationStatus describes state of single migration version.
+// Orphaned migration is applied to database but missing from registered migrations.
+type MigrationStatus struct {
+	Version     uint64    `json:"version"`
+	Description string    `json:"description"`
+	Registered  bool      `json:"registered"`
+	Applied     bool      `json:"applied"`
+	Orphaned    bool      `json:"orphaned"`
+	Dirty       bool      `json:"dirty"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// State returns short human readable state: "applied", "pending", "orphaned" or "dirty".
+func (s MigrationStatus) State() string {
+	switch {
+	case s.Dirty:
+		return "dirty"
+	case s.Orphaned:
+		return "orphaned"
+	case s.Applied:
+		return "applied"
+	}
+	return "pending"
+}
+
+// Status returns state of every registered or applied migration sorted by version.
+func (m *Migrate) Status() ([]MigrationStatus, error) {
+	return m.StatusContext(context.Background())
+}
+
+// StatusContext acts like Status but uses provided context for database operations.
+func (m *Migrate) StatusContext(ctx context.Context) ([]MigrationStatus, error) {
+	// history also upgrades legacy records
+	if _, err := m.history(ctx); err != nil {
+		return nil, err
+	}
+	res, err := m.historyCollection().Find(ctx, m.recordsFilter(nil), options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var recs []versionRecord
+	if err := res.All(ctx, &recs); err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[uint64]*MigrationStatus, len(m.migrations)+len(recs))
+	for _, migration := range m.migrations {
+		statuses[migration.Version] = &MigrationStatus{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Registered:  true,
+		}
+	}
+	for _, rec := range recs {
+		status, ok := statuses[rec.Version]
+		if !ok {
+			status = &MigrationStatus{Version: rec.Version, Description: rec.Description}
+			statuses[rec.Version] = status
+		}
+		status.Applied = rec.Applied
+		status.Dirty = rec.Dirty
+		status.Orphaned = rec.Applied && !status.Registered
+		if rec.Applied {
+			status.AppliedAt = rec.Timestamp
+		}
+	}
+
+	ret := make([]MigrationStatus, 0, len(statuses))
+	for _, status := range statuses {
+		ret = append(ret, *status)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Version < ret[j].Version
+	})
+	return ret, nil
+}
@@ -0,0 +1,191 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// systemDatabases are never matched by database pattern.
+var systemDatabases = map[string]bool{"admin": true, "local": true, "config": true}
+
+// TenantMigrate applies the same migrations to several databases of one cluster, e.g. one database per customer.
+// Each database is versioned independently by its own Migrate.
+// Migrations should use DatabaseFromContext to get name of database they are applied to.
+type TenantMigrate struct {
+	db              *mongo.Client
+	migrations      []Migration
+	pattern         *regexp.Regexp
+	databases       []string
+	parallelism     int
+	continueOnError bool
+	configure       []func(m *Migrate)
+}
+
+// TenantResult is a result of migrations for single database.
+// Skipped is set if migrations were not started because of previous failure or cancelled context.
+type TenantResult struct {
+	Database    string
+	FromVersion uint64
+	ToVersion   uint64
+	Duration    time.Duration
+	Skipped     bool
+	Err         error
+}
+
+// TenantReport is a summary of migrations for all databases.
+type TenantReport struct {
+	Results []TenantResult
+}
+
+// Failed returns results of databases failed to migrate.
+func (r *TenantReport) Failed() []TenantResult {
+	var failed []TenantResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// NewTenantMigrate returns TenantMigrate for provided migrations.
+// Use GetMigrations to apply globally registered migrations.
+func NewTenantMigrate(db *mongo.Client, migrations ...Migration) *TenantMigrate {
+	internalMigrations := make([]Migration, len(migrations))
+	copy(internalMigrations, migrations)
+	return &TenantMigrate{
+		db:          db,
+		migrations:  internalMigrations,
+		parallelism: 1,
+	}
+}
+
+// SetDatabasePattern selects databases which names match pattern. System databases are never selected.
+func (t *TenantMigrate) SetDatabasePattern(pattern *regexp.Regexp) {
+	t.pattern = pattern
+}
+
+// SetDatabases selects provided databases instead of listing them.
+func (t *TenantMigrate) SetDatabases(names ...string) {
+	t.databases = append([]string(nil), names...)
+}
+
+// SetParallelism sets how many databases are migrated at once. By default it is 1.
+func (t *TenantMigrate) SetParallelism(n int) {
+	t.parallelism = n
+}
+
+// SetContinueOnError makes TenantMigrate migrate remaining databases after failure.
+// By default no new databases are started after first failure.
+func (t *TenantMigrate) SetContinueOnError(continueOnError bool) {
+	t.continueOnError = continueOnError
+}
+
+// Configure registers function which configures Migrate of each database,
+// e.g. to set logger, listeners or migrations collection.
+func (t *TenantMigrate) Configure(fn func(m *Migrate)) {
+	t.configure = append(t.configure, fn)
+}
+
+// Databases returns names of selected databases sorted by name.
+func (t *TenantMigrate) Databases(ctx context.Context) ([]string, error) {
+	if t.databases != nil {
+		return append([]string(nil), t.databases...), nil
+	}
+	if t.pattern == nil {
+		return nil, fmt.Errorf("neither databases nor database pattern provided")
+	}
+	names, err := t.db.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var databases []string
+	for _, name := range names {
+		if !systemDatabases[name] && t.pattern.MatchString(name) {
+			databases = append(databases, name)
+		}
+	}
+	sort.Strings(databases)
+	return databases, nil
+}
+
+// Migrate returns Migrate of provided database configured with Configure functions.
+func (t *TenantMigrate) Migrate(database string) *Migrate {
+	m := NewMigrate(database, t.db, t.migrations...)
+	for _, fn := range t.configure {
+		fn(m)
+	}
+	return m
+}
+
+// Up performs "up" migrations on every selected database. See Migrate.Up for n meaning.
+func (t *TenantMigrate) Up(n int) (*TenantReport, error) {
+	return t.UpContext(context.Background(), n)
+}
+
+// UpContext acts like Up but passes ctx to every migration and database operation.
+func (t *TenantMigrate) UpContext(ctx context.Context, n int) (*TenantReport, error) {
+	return t.run(ctx, func(ctx context.Context, m *Migrate) error {
+		return m.UpContext(ctx, n)
+	})
+}
+
+// Down performs "down" migrations on every selected database. See Migrate.Down for n meaning.
+func (t *TenantMigrate) Down(n int) (*TenantReport, error) {
+	return t.DownContext(context.Background(), n)
+}
+
+// DownContext acts like Down but passes ctx to every migration and database operation.
+func (t *TenantMigrate) DownContext(ctx context.Context, n int) (*TenantReport, error) {
+	return t.run(ctx, func(ctx context.Context, m *Migrate) error {
+		return m.DownContext(ctx, n)
+	})
+}
+
+// run calls fn for Migrate of every selected database.
+// Returned error is not nil if any database failed, report holds result of each database.
+func (t *TenantMigrate) run(ctx context.Context, fn func(ctx context.Context, m *Migrate) error) (*TenantReport, error) {
+	databases, err := t.Databases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TenantReport{Results: make([]TenantResult, len(databases))}
+	errs, started := forEach(ctx, len(databases), t.parallelism, !t.continueOnError, func(ctx context.Context, i int) error {
+		result := &report.Results[i]
+		started := time.Now()
+		defer func() { result.Duration = time.Since(started) }()
+
+		m := t.Migrate(databases[i])
+		if result.FromVersion, _, result.Err = m.VersionContext(ctx); result.Err != nil {
+			return result.Err
+		}
+		if result.Err = fn(ctx, m); result.Err != nil {
+			return result.Err
+		}
+		result.ToVersion, _, result.Err = m.VersionContext(ctx)
+		return result.Err
+	})
+
+	failed := 0
+	for i, database := range databases {
+		report.Results[i].Database = database
+		report.Results[i].Skipped = !started[i]
+		if errs[i] != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return report, fmt.Errorf("migrations failed for %d of %d databases", failed, len(databases))
+	}
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
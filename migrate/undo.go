@@ -0,0 +1,420 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const undoCollectionSuffix = "_undo"
+
+// undoBatchSize is a number of documents tracked at once by write methods of TrackedCollection.
+const undoBatchSize = 1000
+
+const (
+	undoInsert  = "insert"
+	undoReplace = "replace"
+)
+
+// undoRecord is a single change made by "up" migration with CaptureUndo option.
+// It`s stored in "<collection>_undo" collection, records are replayed in reverse order of "_id" by "down" migration.
+type undoRecord struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Namespace  string             `bson:"namespace,omitempty"`
+	Version    uint64             `bson:"version"`
+	Database   string             `bson:"database"`
+	Collection string             `bson:"collection"`
+	// Op is undoInsert for inserted document which is deleted on undo
+	// and undoReplace for changed or deleted document which is replaced by PreImage on undo.
+	Op         string        `bson:"op"`
+	DocumentID bson.RawValue `bson:"document_id"`
+	PreImage   bson.Raw      `bson:"pre_image,omitempty"`
+}
+
+func (m *Migrate) undoCollection() *mongo.Collection {
+	return m.db.Database(m.dbName).Collection(m.migrationsCollection + undoCollectionSuffix)
+}
+
+// undoFilter returns filter of undo records of migrate namespace, version is a version or condition on it.
+func (m *Migrate) undoFilter(version interface{}) bson.M {
+	filter := bson.M{"version": version}
+	if m.namespace == "" {
+		filter["namespace"] = bson.M{"$exists": false}
+	} else {
+		filter["namespace"] = m.namespace
+	}
+	return filter
+}
+
+// clearUndo deletes undo records of versions, version is a version or condition on it.
+// Records must be cleared whenever migration record is removed without replaying them,
+// otherwise next "down" replays changes of previous runs too.
+func (m *Migrate) clearUndo(ctx context.Context, version interface{}) error {
+	_, err := m.undoCollection().DeleteMany(ctx, m.undoFilter(version))
+	return err
+}
+
+// clearStaleUndo deletes undo records left by previous runs before fresh "up" migration with CaptureUndo option.
+// Records of dirty migration are kept, they describe changes of failed run which is performed again.
+func (m *Migrate) clearStaleUndo(ctx context.Context, migration Migration) error {
+	dirty, err := m.historyCollection().CountDocuments(ctx,
+		m.recordsFilter(bson.M{"version": migration.Version, "dirty": true}))
+	if err != nil || dirty > 0 {
+		return err
+	}
+	return m.clearUndo(ctx, migration.Version)
+}
+
+// undoDown is "down" callback of migrations with CaptureUndo option and without own "down" callback.
+// It reverts changes recorded by tracked collections and clears undo log of migration.
+func undoDown(ctx context.Context, _ *mongo.Client) error {
+	info := runInfoFromContext(ctx)
+	if info == nil {
+		return errNotRunning
+	}
+	m := info.m
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := m.undoCollection().Find(ctx, m.undoFilter(info.migration.Version), findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	var undone int
+	for cursor.Next(ctx) {
+		var rec undoRecord
+		if err := cursor.Decode(&rec); err != nil {
+			return err
+		}
+		collection := m.db.Database(rec.Database).Collection(rec.Collection)
+		switch rec.Op {
+		case undoInsert:
+			_, err = collection.DeleteOne(ctx, bson.M{"_id": rec.DocumentID})
+		case undoReplace:
+			_, err = collection.ReplaceOne(ctx, bson.M{"_id": rec.DocumentID}, rec.PreImage, options.Replace().SetUpsert(true))
+		default:
+			err = fmt.Errorf("unknown undo operation %q", rec.Op)
+		}
+		if err != nil {
+			return err
+		}
+		undone++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := m.clearUndo(ctx, info.migration.Version); err != nil {
+		return err
+	}
+	m.log().Info("changes undone", "version", info.migration.Version, "changes", undone)
+	return nil
+}
+
+// TrackedCollection wraps collection changed by "up" migration with CaptureUndo option.
+// Its write methods record pre-images of changed and deleted documents and ids of inserted ones into undo log,
+// so "down" migration reverts them precisely without hand-written code.
+// UpdateMany and DeleteMany process matching documents in batches ordered by "_id", so they are not atomic.
+// Changes made by other means, e.g. BulkWrite of wrapped collection, are not recorded.
+// Outside of such migration methods act like methods of wrapped collection.
+type TrackedCollection struct {
+	collection *mongo.Collection
+	info       *runInfo
+}
+
+// TrackCollection returns collection which records changes into undo log of migration running with ctx.
+func TrackCollection(ctx context.Context, collection *mongo.Collection) *TrackedCollection {
+	info := runInfoFromContext(ctx)
+	if info != nil && (info.direction != DirectionUp || !info.migration.Options.CaptureUndo) {
+		info = nil
+	}
+	return &TrackedCollection{collection: collection, info: info}
+}
+
+// Collection returns wrapped collection, e.g. to read documents.
+func (c *TrackedCollection) Collection() *mongo.Collection {
+	return c.collection
+}
+
+// newRecord returns undo record of change made by migration.
+func (c *TrackedCollection) newRecord(op string, id interface{}, preImage bson.Raw) (undoRecord, error) {
+	rawID, err := rawValue(id)
+	if err != nil {
+		return undoRecord{}, err
+	}
+	return undoRecord{
+		ID:         primitive.NewObjectID(),
+		Namespace:  c.info.m.namespace,
+		Version:    c.info.migration.Version,
+		Database:   c.collection.Database().Name(),
+		Collection: c.collection.Name(),
+		Op:         op,
+		DocumentID: rawID,
+		PreImage:   preImage,
+	}, nil
+}
+
+// writeRecords writes undo records in batches of undoBatchSize.
+func (c *TrackedCollection) writeRecords(ctx context.Context, recs []interface{}) error {
+	for len(recs) > 0 {
+		n := len(recs)
+		if n > undoBatchSize {
+			n = undoBatchSize
+		}
+		if _, err := c.info.m.undoCollection().InsertMany(ctx, recs[:n]); err != nil {
+			return err
+		}
+		recs = recs[n:]
+	}
+	return nil
+}
+
+// recordInserted records ids of inserted documents.
+func (c *TrackedCollection) recordInserted(ctx context.Context, ids []interface{}) error {
+	if c.info == nil || len(ids) == 0 {
+		return nil
+	}
+	recs := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		rec, err := c.newRecord(undoInsert, id, nil)
+		if err != nil {
+			return err
+		}
+		recs = append(recs, rec)
+	}
+	return c.writeRecords(ctx, recs)
+}
+
+// trackBatches reads documents matching filter in batches ordered by "_id", records their pre-images
+// and then calls write with ids of batch, so matched documents are never held in memory at once.
+// Positive limit stops after limit documents. It returns number of matched documents.
+func (c *TrackedCollection) trackBatches(ctx context.Context, filter interface{}, limit int64,
+	write func(ids bson.A) error) (int64, error) {
+	var (
+		matched int64
+		lastID  bson.RawValue
+	)
+	for {
+		size := int64(undoBatchSize)
+		if limit > 0 && limit-matched < size {
+			size = limit - matched
+		}
+		if size == 0 {
+			return matched, nil
+		}
+		batchFilter := filter
+		if matched > 0 {
+			// written documents may not match filter anymore, so batches continue after last "_id"
+			batchFilter = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$gt": lastID}}}}
+		}
+		findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(size)
+		cursor, err := c.collection.Find(ctx, batchFilter, findOptions)
+		if err != nil {
+			return matched, err
+		}
+		var docs []bson.Raw
+		if err := cursor.All(ctx, &docs); err != nil {
+			return matched, err
+		}
+		if len(docs) == 0 {
+			return matched, nil
+		}
+
+		recs := make([]interface{}, 0, len(docs))
+		ids := make(bson.A, 0, len(docs))
+		for _, doc := range docs {
+			id := doc.Lookup("_id")
+			rec, err := c.newRecord(undoReplace, id, doc)
+			if err != nil {
+				return matched, err
+			}
+			recs = append(recs, rec)
+			ids = append(ids, id)
+		}
+		if err := c.writeRecords(ctx, recs); err != nil {
+			return matched, err
+		}
+		if err := write(ids); err != nil {
+			return matched, err
+		}
+		matched += int64(len(docs))
+		lastID = docs[len(docs)-1].Lookup("_id")
+		if int64(len(docs)) < size {
+			return matched, nil
+		}
+	}
+}
+
+// InsertOne acts like mongo.Collection.InsertOne and records id of inserted document.
+func (c *TrackedCollection) InsertOne(ctx context.Context, document interface{},
+	opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	res, err := c.collection.InsertOne(ctx, document, opts...)
+	if err != nil {
+		return res, err
+	}
+	return res, c.recordInserted(ctx, []interface{}{res.InsertedID})
+}
+
+// InsertMany acts like mongo.Collection.InsertMany and records ids of inserted documents.
+func (c *TrackedCollection) InsertMany(ctx context.Context, documents []interface{},
+	opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	res, err := c.collection.InsertMany(ctx, documents, opts...)
+	if res != nil {
+		ordered := options.MergeInsertManyOptions(opts...).Ordered
+		ids := insertedIDs(res.InsertedIDs, len(documents), err, ordered == nil || *ordered)
+		if recordErr := c.recordInserted(ctx, ids); recordErr != nil {
+			return res, recordErr
+		}
+	}
+	return res, err
+}
+
+// insertedIDs returns ids of documents actually inserted by failed InsertMany, so documents
+// which already existed are never deleted on undo. Ids of failed documents are dropped by their index,
+// ordered insert stops at first failure. If it`s unknown which documents were inserted, nothing is returned.
+func insertedIDs(ids []interface{}, n int, err error, ordered bool) []interface{} {
+	if err == nil {
+		return ids
+	}
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) {
+		return nil
+	}
+	if len(ids) != n {
+		// driver already removed ids of failed documents
+		return ids
+	}
+	failed := make(map[int]bool, len(bulkErr.WriteErrors))
+	first := n
+	for _, we := range bulkErr.WriteErrors {
+		failed[we.Index] = true
+		if we.Index < first {
+			first = we.Index
+		}
+	}
+	if ordered {
+		return ids[:first]
+	}
+	inserted := make([]interface{}, 0, len(ids))
+	for i, id := range ids {
+		if !failed[i] {
+			inserted = append(inserted, id)
+		}
+	}
+	return inserted
+}
+
+// UpdateOne acts like mongo.Collection.UpdateOne and records pre-image of updated document.
+func (c *TrackedCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{},
+	opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if c.info == nil {
+		return c.collection.UpdateOne(ctx, filter, update, opts...)
+	}
+	var res *mongo.UpdateResult
+	matched, err := c.trackBatches(ctx, filter, 1, func(ids bson.A) (err error) {
+		res, err = c.collection.UpdateOne(ctx, bson.M{"_id": ids[0]}, update, opts...)
+		return err
+	})
+	if err != nil || matched > 0 {
+		return res, err
+	}
+	return c.recordUpsert(ctx)(c.collection.UpdateOne(ctx, filter, update, opts...))
+}
+
+// UpdateMany acts like mongo.Collection.UpdateMany and records pre-images of updated documents.
+// Documents are updated in batches after their pre-images are recorded.
+func (c *TrackedCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{},
+	opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if c.info == nil {
+		return c.collection.UpdateMany(ctx, filter, update, opts...)
+	}
+	res := &mongo.UpdateResult{}
+	matched, err := c.trackBatches(ctx, filter, 0, func(ids bson.A) error {
+		batch, err := c.collection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, update, opts...)
+		if err != nil {
+			return err
+		}
+		res.MatchedCount += batch.MatchedCount
+		res.ModifiedCount += batch.ModifiedCount
+		return nil
+	})
+	if err != nil || matched > 0 {
+		return res, err
+	}
+	return c.recordUpsert(ctx)(c.collection.UpdateMany(ctx, filter, update, opts...))
+}
+
+// ReplaceOne acts like mongo.Collection.ReplaceOne and records pre-image of replaced document.
+func (c *TrackedCollection) ReplaceOne(ctx context.Context, filter interface{}, replacement interface{},
+	opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	if c.info == nil {
+		return c.collection.ReplaceOne(ctx, filter, replacement, opts...)
+	}
+	var res *mongo.UpdateResult
+	matched, err := c.trackBatches(ctx, filter, 1, func(ids bson.A) (err error) {
+		res, err = c.collection.ReplaceOne(ctx, bson.M{"_id": ids[0]}, replacement, opts...)
+		return err
+	})
+	if err != nil || matched > 0 {
+		return res, err
+	}
+	return c.recordUpsert(ctx)(c.collection.ReplaceOne(ctx, filter, replacement, opts...))
+}
+
+// recordUpsert returns function which records document inserted by upsert.
+func (c *TrackedCollection) recordUpsert(ctx context.Context) func(*mongo.UpdateResult, error) (*mongo.UpdateResult, error) {
+	return func(res *mongo.UpdateResult, err error) (*mongo.UpdateResult, error) {
+		if err != nil || res.UpsertedID == nil {
+			return res, err
+		}
+		return res, c.recordInserted(ctx, []interface{}{res.UpsertedID})
+	}
+}
+
+// DeleteOne acts like mongo.Collection.DeleteOne and records pre-image of deleted document.
+func (c *TrackedCollection) DeleteOne(ctx context.Context, filter interface{},
+	opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	if c.info == nil {
+		return c.collection.DeleteOne(ctx, filter, opts...)
+	}
+	res := &mongo.DeleteResult{}
+	_, err := c.trackBatches(ctx, filter, 1, func(ids bson.A) (err error) {
+		res, err = c.collection.DeleteOne(ctx, bson.M{"_id": ids[0]}, opts...)
+		return err
+	})
+	return res, err
+}
+
+// DeleteMany acts like mongo.Collection.DeleteMany and records pre-images of deleted documents.
+// Documents are deleted in batches after their pre-images are recorded.
+func (c *TrackedCollection) DeleteMany(ctx context.Context, filter interface{},
+	opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	if c.info == nil {
+		return c.collection.DeleteMany(ctx, filter, opts...)
+	}
+	res := &mongo.DeleteResult{}
+	_, err := c.trackBatches(ctx, filter, 0, func(ids bson.A) error {
+		batch, err := c.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts...)
+		if err != nil {
+			return err
+		}
+		res.DeletedCount += batch.DeletedCount
+		return nil
+	})
+	return res, err
+}
+
+// rawValue marshals document id, so ids of any type are stored as is.
+func rawValue(v interface{}) (bson.RawValue, error) {
+	if raw, ok := v.(bson.RawValue); ok {
+		return raw, nil
+	}
+	t, data, err := bson.MarshalValue(v)
+	if err != nil {
+		return bson.RawValue{}, err
+	}
+	return bson.RawValue{Type: t, Value: data}, nil
+}
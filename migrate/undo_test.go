@@ -0,0 +1,85 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCaptureUndoDown(t *testing.T) {
+	migration := Migration{Options: MigrationOptions{CaptureUndo: true}}
+	if migration.down() == nil {
+		t.Errorf("Migration capturing undo log must have down callback")
+	}
+	step := newPlanStep(migration, DirectionUp)
+	if !step.HasDown {
+		t.Errorf("Unexpected plan step: %+v", step)
+	}
+	if err := migration.down()(context.Background(), nil); err != errNotRunning {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestTrackCollectionDisabled(t *testing.T) {
+	migrate := NewMigrate("db", nil)
+	for _, info := range []*runInfo{
+		{m: migrate, migration: Migration{}, direction: DirectionUp},
+		{m: migrate, migration: Migration{Options: MigrationOptions{CaptureUndo: true}}, direction: DirectionDown},
+	} {
+		if c := TrackCollection(withRunInfo(context.Background(), info), nil); c.info != nil {
+			t.Errorf("Changes must not be tracked for %+v", info)
+		}
+	}
+	if c := TrackCollection(context.Background(), nil); c.info != nil {
+		t.Errorf("Changes must not be tracked outside of migration")
+	}
+}
+
+func TestRawValue(t *testing.T) {
+	id := primitive.NewObjectID()
+	raw, err := rawValue(id)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+	if raw.ObjectID() != id {
+		t.Errorf("Unexpected value: %v", raw)
+	}
+	doc, err := bson.Marshal(bson.M{"_id": "a"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lookup := bson.Raw(doc).Lookup("_id")
+	if raw, err := rawValue(lookup); err != nil || raw.StringValue() != "a" {
+		t.Errorf("Unexpected value: %v %v", raw, err)
+	}
+}
+
+func TestInsertedIDs(t *testing.T) {
+	ids := []interface{}{1, 2, 3, 4}
+	bulkErr := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Index: 3}},
+		{WriteError: mongo.WriteError{Index: 1}},
+	}}
+	for _, tc := range []struct {
+		ids      []interface{}
+		err      error
+		ordered  bool
+		expected []interface{}
+	}{
+		{ids, nil, true, ids},
+		{ids, bulkErr, true, []interface{}{1}},
+		{ids, bulkErr, false, []interface{}{1, 3}},
+		{ids[:2], bulkErr, false, ids[:2]},
+		{ids, errors.New("network"), false, nil},
+	} {
+		if inserted := insertedIDs(tc.ids, len(ids), tc.err, tc.ordered); !reflect.DeepEqual(inserted, tc.expected) {
+			t.Errorf("Unexpected ids for %v, ordered %v: %v", tc.err, tc.ordered, inserted)
+		}
+	}
+}